@@ -0,0 +1,55 @@
+// Command apikey prints a new API key and the entry that accepts it in the API_KEYS_FILE of the
+// server. Use it to bootstrap the first admin key:
+//
+//	go run ./cmd/apikey -id bootstrap -name ops -scopes admin
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sofa-logs-servers/infra/auth"
+	"sofa-logs-servers/infra/tenant"
+	"strings"
+	"time"
+)
+
+func main() {
+	id := flag.String("id", "", "key id, unique within the key file")
+	name := flag.String("name", "", "who or what the key is for")
+	tenantID := flag.String("tenant", "", "tenant the key is bound to, any tenant when empty")
+	scopes := flag.String("scopes", string(auth.ScopeRead), "comma separated scopes, roles of the access policy like ingest, read or admin")
+	flag.Parse()
+
+	if *id == "" || strings.Contains(*id, ".") {
+		fmt.Fprintln(os.Stderr, "-id is required and cannot contain a dot")
+		os.Exit(2)
+	}
+
+	secret, err := auth.NewSecret()
+	if err != nil {
+		panic(err)
+	}
+
+	if *tenantID != "" {
+		if err := tenant.Validate(*tenantID); err != nil {
+			fmt.Fprintln(os.Stderr, "-tenant:", err)
+			os.Exit(2)
+		}
+	}
+
+	key := auth.Key{ID: *id, Name: *name, Hash: auth.Hash(secret), Tenant: *tenantID, CreatedAt: time.Now().UTC()}
+	for _, scope := range strings.Split(*scopes, ",") {
+		key.Scopes = append(key.Scopes, auth.Scope(strings.TrimSpace(scope)))
+	}
+
+	entry, err := json.MarshalIndent(key, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println("key:", *id+"."+secret)
+	fmt.Println("key file entry:")
+	fmt.Println(string(entry))
+}
@@ -0,0 +1,88 @@
+// Command reindex migrates the documents written before the models had JSON field names. Those
+// were stored with the Go field names, like "UserID" and "StartedAt", which the index mappings and
+// queries no longer know: reindex renames them to user_id, started_at and so on, adds duration_ms
+// to request logs, and writes every changed document back under its id.
+//
+// Such documents only exist in Zinc, the one backend of that time, in the indices of the default
+// tenant. reindex reads the same configuration as the server:
+//
+//	go run ./cmd/reindex -storage.url http://localhost:4080 -storage.username admin
+//
+// Run it once after upgrading; documents already migrated are left alone, so running it again is
+// harmless.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"sofa-logs-servers/config"
+	"sofa-logs-servers/infra/storage"
+	"sofa-logs-servers/infra/zincsearch"
+	"sofa-logs-servers/models"
+)
+
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if cfg.Storage.Backend != "zincsearch" {
+		return fmt.Errorf("reindex: only zincsearch indices hold legacy documents, not %s", cfg.Storage.Backend)
+	}
+
+	store, err := zincsearch.NewClient(cfg.Storage.URL, cfg.Storage.Username, cfg.Storage.Password, slog.Default())
+	if err != nil {
+		return err
+	}
+
+	for _, index := range []string{cfg.Indices.Logs, cfg.Indices.Transactions} {
+		migrated, total, err := reindex(context.Background(), store, index)
+		if err != nil {
+			return fmt.Errorf("reindex: %s: %w", index, err)
+		}
+		fmt.Printf("%s: migrated %d of %d documents\n", index, migrated, total)
+	}
+	return nil
+}
+
+// reindex rewrites the legacy documents of index. Every document is read before the first one is
+// written, so the rewrites cannot shift the pages being read.
+func reindex(ctx context.Context, store storage.Storage, index string) (int, int, error) {
+	hits, err := storage.SearchAll(ctx, store, index, storage.Query{Sort: []storage.SortField{{Field: "_id"}}})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	migrated := 0
+	for _, hit := range hits {
+		source := map[string]interface{}{}
+		if err := json.Unmarshal(hit.Source, &source); err != nil {
+			return migrated, len(hits), fmt.Errorf("document %s: %w", hit.ID, err)
+		}
+
+		document, changed := models.FromLegacy(source)
+		if !changed {
+			continue
+		}
+		if err := store.Update(ctx, index, hit.ID, document); err != nil {
+			return migrated, len(hits), fmt.Errorf("document %s: %w", hit.ID, err)
+		}
+		migrated++
+	}
+	return migrated, len(hits), nil
+}
@@ -1,55 +1,261 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"sofa-logs-servers/config"
+	"sofa-logs-servers/infra/auth"
+	"sofa-logs-servers/infra/elasticsearch"
+	"sofa-logs-servers/infra/embedded"
+	"sofa-logs-servers/infra/health"
+	"sofa-logs-servers/infra/ingest"
+	"sofa-logs-servers/infra/logging"
+	"sofa-logs-servers/infra/metrics"
+	"sofa-logs-servers/infra/ratelimit"
+	"sofa-logs-servers/infra/storage"
+	"sofa-logs-servers/infra/tenant"
+	"sofa-logs-servers/infra/tracing"
 	"sofa-logs-servers/infra/zincsearch"
+	"sofa-logs-servers/models"
+	healthroutes "sofa-logs-servers/routes/health"
+	apikeys "sofa-logs-servers/routes/keys"
 	"sofa-logs-servers/routes/requests"
 	"sofa-logs-servers/routes/transactions"
 	"sofa-logs-servers/utils"
+	"strconv"
+	"syscall"
+	"time"
 
-	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
-	_ "github.com/joho/godotenv/autoload"
-	"github.com/rs/cors"
 )
 
+// closer releases a resource on shutdown, once no request uses it anymore.
+type closer func(ctx context.Context) error
+
+// initStorage connects to the configured backend and makes sure the indices exist. API keys are
+// read and written straight through the backend, never through the ingest queue, and are shared
+// by all tenants. The closers flush and release the storage, in order.
+func initStorage(cfg config.Config, logger *slog.Logger, quota *ratelimit.Quota) (storage.Storage, *auth.KeyStore, []closer, error) {
+	var store storage.Storage
+	var closers []closer
+	var err error
+
+	switch cfg.Storage.Backend {
+	case "zincsearch":
+		store, err = zincsearch.NewClient(cfg.Storage.URL, cfg.Storage.Username, cfg.Storage.Password, logger)
+	case "elasticsearch", "opensearch":
+		store, err = elasticsearch.NewClient(cfg.Storage.URL, cfg.Storage.Username, cfg.Storage.Password, logger)
+	case "embedded":
+		var embeddedStore *embedded.Store
+		embeddedStore, err = embedded.Open(cfg.Storage.EmbeddedFile)
+		if err == nil {
+			store = embeddedStore
+			closers = append(closers, func(context.Context) error { return embeddedStore.Close() })
+		}
+	default:
+		return nil, nil, nil, fmt.Errorf("unknown storage backend %q", cfg.Storage.Backend)
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	store = metrics.NewStore(store, cfg.Indices.Logs, cfg.Indices.Transactions, auth.Index)
+
+	keys, err := auth.Init(store, cfg.Auth.KeysFile)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if cfg.Ingest.Queue {
+		queue, err := ingest.Open(store, ingest.Config{
+			WALPath:       cfg.Ingest.WALFile,
+			BatchSize:     cfg.Ingest.BatchSize,
+			FlushInterval: cfg.Ingest.FlushInterval,
+			MaxPending:    cfg.Ingest.MaxPending,
+		})
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		metrics.QueueDepth(queue.Pending)
+		store = queue
+		// The queue flushes into the backend, so it has to close first.
+		closers = append([]closer{queue.Close}, closers...)
+	}
+
+	tenants := tenant.New(store, map[string]string{
+		"requests":     cfg.Indices.Logs,
+		"transactions": cfg.Indices.Transactions,
+	})
+
+	err = tenants.EnsureIndex(context.Background(), "requests", models.LogMapping)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	err = tenants.EnsureIndex(context.Background(), "transactions", models.TransactionMapping)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return ratelimit.NewQuotaStore(tenants, quota), keys, closers, nil
+}
+
+// newServer applies the configured timeouts to the HTTP server.
+func newServer(cfg config.Server, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              ":" + strconv.Itoa(cfg.Port),
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	}
+}
+
 func main() {
-	router := mux.NewRouter()
+	if err := run(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	logger, err := logging.New(os.Stdout, cfg.Log.Format, cfg.Log.Level)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
 
-	c := cors.New(cors.Options{
-		AllowedOrigins: []string{"*"},
-		AllowedMethods: []string{
-			http.MethodPost,
-			http.MethodGet,
-		},
-		AllowedHeaders:   []string{"*"},
-		AllowCredentials: false,
+	shutdownTracing, err := tracing.Init(context.Background(), tracing.Config{
+		Exporter:    cfg.Tracing.Exporter,
+		Endpoint:    cfg.Tracing.Endpoint,
+		File:        cfg.Tracing.File,
+		ServiceName: cfg.Tracing.ServiceName,
+		SampleRatio: cfg.Tracing.SampleRatio,
 	})
+	if err != nil {
+		return err
+	}
+
+	router := mux.NewRouter()
+
+	limitConfig, err := ratelimit.Init(cfg.RateLimit.File)
+	if err != nil {
+		return err
+	}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8082"
+	quota, err := ratelimit.OpenQuota(limitConfig.Quota)
+	if err != nil {
+		return err
 	}
 
-	zincClient, err := zincsearch.Init()
-	utils.PanicErr(err)
+	store, keys, closers, err := initStorage(cfg, logger, quota)
+	if err != nil {
+		return err
+	}
+	// Spans of the shutdown itself are exported last.
+	closers = append(closers, func(context.Context) error { return quota.Close() }, shutdownTracing)
 
-	router.HandleFunc("/api/logs/create", utils.Middleware(requests.Create, zincClient))
-	router.HandleFunc("/api/logs/update", utils.Middleware(requests.Update, zincClient))
-	router.HandleFunc("/api/logs/delete", utils.Middleware(requests.Delete, zincClient))
-	router.HandleFunc("/api/logs/id", utils.Middleware(requests.FindById, zincClient))
-	router.HandleFunc("/api/logs/all", utils.Middleware(requests.FindAll, zincClient))
-	router.HandleFunc("/api/transactions/create", utils.Middleware(transactions.Create, zincClient))
-	router.HandleFunc("/api/transactions/update", utils.Middleware(transactions.Update, zincClient))
-	router.HandleFunc("/api/transactions/delete", utils.Middleware(transactions.Delete, zincClient))
-	router.HandleFunc("/api/transactions/all", utils.Middleware(transactions.FindAll, zincClient))
-	router.HandleFunc("/api/transactions/id", utils.Middleware(transactions.FindById, zincClient))
+	defaultScopes := make([]auth.Scope, len(cfg.Auth.JWT.DefaultScopes))
+	for i, scope := range cfg.Auth.JWT.DefaultScopes {
+		defaultScopes[i] = auth.Scope(scope)
+	}
+	tokens, err := auth.InitTokens(auth.TokenConfig{
+		Secret:        []byte(cfg.Auth.JWT.Secret),
+		JWKSPath:      cfg.Auth.JWT.JWKSFile,
+		Issuer:        cfg.Auth.JWT.Issuer,
+		Audience:      cfg.Auth.JWT.Audience,
+		DefaultScopes: defaultScopes,
+	})
+	if err != nil {
+		return err
+	}
 
-	fmt.Println("server started at " + port)
-	err = http.ListenAndServe(":"+port, handlers.LoggingHandler(os.Stdout, c.Handler(router)))
+	policy, err := auth.InitPolicy(cfg.Auth.PolicyFile)
 	if err != nil {
-		panic(err)
+		return err
+	}
+
+	authenticator := auth.Authenticator{Keys: keys, Tokens: tokens}
+	resolveTenant := utils.ResolveTenant(cfg.Tenants.Required)
+	limits := ratelimit.NewLimits(limitConfig)
+	limitIP, rateLimit := utils.LimitIP(limits), utils.RateLimit(limits)
+	allow := func(index string, op auth.Operation) utils.Layer {
+		authorize := utils.Authorize(authenticator, policy, index, op)
+		return func(next http.HandlerFunc) http.HandlerFunc {
+			return utils.Chain(next, limitIP, authorize, resolveTenant, rateLimit)
+		}
+	}
+
+	checker := health.NewChecker(5*time.Second, 2*time.Second, map[string]health.Probe{
+		"requests":     health.IndexProbe(store, "requests"),
+		"transactions": health.IndexProbe(store, "transactions"),
+	})
+
+	router.Use(tracing.Route, metrics.Middleware)
+	router.Handle("/metrics", metrics.Handler())
+	router.HandleFunc("/healthz", healthroutes.Live)
+	router.HandleFunc("/readyz", healthroutes.Ready(checker))
+
+	router.HandleFunc("/api/logs/create", utils.Middleware(requests.Create, store, allow("requests", auth.OpCreate)))
+	router.HandleFunc("/api/logs/update", utils.Middleware(requests.Update, store, allow("requests", auth.OpUpdate)))
+	router.HandleFunc("/api/logs/delete", utils.Middleware(requests.Delete, store, allow("requests", auth.OpDelete)))
+	router.HandleFunc("/api/logs/id", utils.Middleware(requests.FindById, store, allow("requests", auth.OpRead)))
+	router.HandleFunc("/api/logs/all", utils.Middleware(requests.FindAll, store, allow("requests", auth.OpRead)))
+	router.HandleFunc("/api/logs/bulk", utils.Middleware(requests.Bulk, store, allow("requests", auth.OpCreate)))
+	router.HandleFunc("/api/logs/stats", utils.Middleware(requests.Stats, store, allow("requests", auth.OpRead)))
+	router.HandleFunc("/api/transactions/create", utils.Middleware(transactions.Create, store, allow("transactions", auth.OpCreate)))
+	router.HandleFunc("/api/transactions/update", utils.Middleware(transactions.Update, store, allow("transactions", auth.OpUpdate)))
+	router.HandleFunc("/api/transactions/delete", utils.Middleware(transactions.Delete, store, allow("transactions", auth.OpDelete)))
+	router.HandleFunc("/api/transactions/all", utils.Middleware(transactions.FindAll, store, allow("transactions", auth.OpRead)))
+	router.HandleFunc("/api/transactions/id", utils.Middleware(transactions.FindById, store, allow("transactions", auth.OpRead)))
+	router.HandleFunc("/api/transactions/bulk", utils.Middleware(transactions.Bulk, store, allow("transactions", auth.OpCreate)))
+	router.HandleFunc("/api/transactions/histogram", utils.Middleware(transactions.Histogram, store, allow("transactions", auth.OpRead)))
+	router.HandleFunc("/api/keys/create", utils.Chain(apikeys.Create(keys, policy), limitIP, utils.Authorize(authenticator, policy, auth.Index, auth.OpCreate)))
+	router.HandleFunc("/api/keys/revoke", utils.Chain(apikeys.Revoke(keys), limitIP, utils.Authorize(authenticator, policy, auth.Index, auth.OpUpdate)))
+
+	server := newServer(cfg.Server, utils.Recover(tracing.Handler(utils.RequestID(logger)(utils.CORS(cfg.CORS, router)))))
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.ListenAndServe()
+	}()
+	logger.Info("server started", "port", cfg.Server.Port)
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+	stop()
+
+	// Stop accepting connections and let in-flight requests finish, then flush what they queued.
+	logger.Info("shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logger.Error("draining requests failed", "err", err)
+	}
+	for _, release := range closers {
+		if err := release(shutdownCtx); err != nil {
+			logger.Error("releasing storage failed", "err", err)
+		}
 	}
+	return nil
 }
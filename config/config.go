@@ -0,0 +1,341 @@
+// Package config loads the settings of the server. Every setting has a default and can be set in
+// a YAML file, an environment variable and a command-line flag, later sources overriding earlier
+// ones. Flags are named after the YAML path of the setting, like -server.port.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"sofa-logs-servers/infra/auth"
+	"sofa-logs-servers/infra/tenant"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+type Config struct {
+	Server    Server    `yaml:"server"`
+	CORS      CORS      `yaml:"cors"`
+	Storage   Storage   `yaml:"storage"`
+	Indices   Indices   `yaml:"indices"`
+	Ingest    Ingest    `yaml:"ingest"`
+	Auth      Auth      `yaml:"auth"`
+	Tenants   Tenants   `yaml:"tenants"`
+	RateLimit RateLimit `yaml:"rate_limit"`
+	Log       Log       `yaml:"log"`
+	Tracing   Tracing   `yaml:"tracing"`
+}
+
+type Server struct {
+	Port              int           `yaml:"port"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	ReadTimeout       time.Duration `yaml:"read_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`
+	// ShutdownTimeout bounds draining requests and flushing storage on shutdown.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+}
+
+// CORS is the cross-origin policy of every route, except the routes matched by a pattern of
+// Routes, which override the fields they set.
+type CORS struct {
+	// AllowedOrigins are origins or patterns like https://*.example.com. "*" allows any origin.
+	AllowedOrigins   []string             `yaml:"allowed_origins"`
+	AllowedMethods   []string             `yaml:"allowed_methods"`
+	AllowedHeaders   []string             `yaml:"allowed_headers"`
+	ExposedHeaders   []string             `yaml:"exposed_headers"`
+	AllowCredentials bool                 `yaml:"allow_credentials"`
+	MaxAge           time.Duration        `yaml:"max_age"`
+	Routes           map[string]CORSRoute `yaml:"routes"`
+}
+
+// CORSRoute overrides the CORS policy for the paths matching its pattern, like /api/keys/*.
+type CORSRoute struct {
+	AllowedOrigins   []string      `yaml:"allowed_origins"`
+	AllowedMethods   []string      `yaml:"allowed_methods"`
+	AllowedHeaders   []string      `yaml:"allowed_headers"`
+	ExposedHeaders   []string      `yaml:"exposed_headers"`
+	AllowCredentials *bool         `yaml:"allow_credentials"`
+	MaxAge           time.Duration `yaml:"max_age"`
+}
+
+// Route returns the policy of the paths matching pattern.
+func (c CORS) Route(pattern string) CORS {
+	route := c.Routes[pattern]
+	policy := c
+	policy.Routes = nil
+	if route.AllowedOrigins != nil {
+		policy.AllowedOrigins = route.AllowedOrigins
+	}
+	if route.AllowedMethods != nil {
+		policy.AllowedMethods = route.AllowedMethods
+	}
+	if route.AllowedHeaders != nil {
+		policy.AllowedHeaders = route.AllowedHeaders
+	}
+	if route.ExposedHeaders != nil {
+		policy.ExposedHeaders = route.ExposedHeaders
+	}
+	if route.AllowCredentials != nil {
+		policy.AllowCredentials = *route.AllowCredentials
+	}
+	if route.MaxAge != 0 {
+		policy.MaxAge = route.MaxAge
+	}
+	return policy
+}
+
+type Storage struct {
+	// Backend is zincsearch, elasticsearch, opensearch or embedded.
+	Backend  string `yaml:"backend"`
+	URL      string `yaml:"url"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// EmbeddedFile persists the embedded backend, which only keeps data in memory without it.
+	EmbeddedFile string `yaml:"embedded_file"`
+}
+
+// Indices names the indices behind the logs and transactions routes.
+type Indices struct {
+	Logs         string `yaml:"logs"`
+	Transactions string `yaml:"transactions"`
+}
+
+type Ingest struct {
+	Queue         bool          `yaml:"queue"`
+	WALFile       string        `yaml:"wal_file"`
+	BatchSize     int           `yaml:"batch_size"`
+	FlushInterval time.Duration `yaml:"flush_interval"`
+	MaxPending    int           `yaml:"max_pending"`
+}
+
+type Auth struct {
+	KeysFile   string `yaml:"keys_file"`
+	PolicyFile string `yaml:"policy_file"`
+	JWT        JWT    `yaml:"jwt"`
+}
+
+type JWT struct {
+	Secret        string   `yaml:"secret"`
+	JWKSFile      string   `yaml:"jwks_file"`
+	Issuer        string   `yaml:"issuer"`
+	Audience      string   `yaml:"audience"`
+	DefaultScopes []string `yaml:"default_scopes"`
+}
+
+type Tenants struct {
+	Required bool `yaml:"required"`
+}
+
+type RateLimit struct {
+	File string `yaml:"file"`
+}
+
+// Tracing is handed to tracing.Init as is, see tracing.Config for its fields.
+type Tracing struct {
+	Exporter    string  `yaml:"exporter"`
+	Endpoint    string  `yaml:"endpoint"`
+	File        string  `yaml:"file"`
+	ServiceName string  `yaml:"service_name"`
+	SampleRatio float64 `yaml:"sample_ratio"`
+}
+
+type Log struct {
+	// Level is debug, info, warn or error.
+	Level string `yaml:"level"`
+	// Format is json or text.
+	Format string `yaml:"format"`
+}
+
+func Default() Config {
+	return Config{
+		Server: Server{
+			Port:              8082,
+			ReadHeaderTimeout: 5 * time.Second,
+			ReadTimeout:       30 * time.Second,
+			WriteTimeout:      60 * time.Second,
+			IdleTimeout:       120 * time.Second,
+			ShutdownTimeout:   30 * time.Second,
+		},
+		CORS: CORS{
+			AllowedOrigins: []string{"*"},
+			AllowedMethods: []string{http.MethodGet, http.MethodPost},
+			AllowedHeaders: []string{"*"},
+			// Browsers hide response headers not listed here from scripts.
+			ExposedHeaders: []string{"X-Request-ID", "Retry-After"},
+		},
+		Storage: Storage{Backend: "zincsearch"},
+		Indices: Indices{Logs: "requests", Transactions: "transactions"},
+		Ingest: Ingest{
+			WALFile:       "ingest.wal",
+			BatchSize:     500,
+			FlushInterval: time.Second,
+			MaxPending:    100000,
+		},
+		Auth: Auth{JWT: JWT{DefaultScopes: []string{"ingest"}}},
+		Log:  Log{Level: "info", Format: "json"},
+		Tracing: Tracing{
+			Exporter:    "none",
+			ServiceName: "sofa-logs-servers",
+			SampleRatio: 1,
+		},
+	}
+}
+
+// Load reads the configuration file named by -config or CONFIG_FILE, then the environment, which
+// may come from a .env file, then the flags in args.
+func Load(args []string) (Config, error) {
+	config := Default()
+	settings := config.settings()
+
+	// CONFIG_FILE may come from .env too, and it is read for the default of -config.
+	if err := godotenv.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return Config{}, fmt.Errorf("config: .env: %w", err)
+	}
+
+	flags := flag.NewFlagSet("server", flag.ContinueOnError)
+	file := flags.String("config", os.Getenv("CONFIG_FILE"), "YAML configuration file")
+	for _, s := range settings {
+		flags.String(s.path, "", s.usage+" (env "+s.env+")")
+	}
+	if err := flags.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	if *file != "" {
+		data, err := os.ReadFile(*file)
+		if err != nil {
+			return Config{}, fmt.Errorf("config: %w", err)
+		}
+		decoder := yaml.NewDecoder(strings.NewReader(string(data)))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&config); err != nil {
+			return Config{}, fmt.Errorf("config: %s: %w", *file, err)
+		}
+	}
+
+	var problems []string
+	for _, s := range settings {
+		if value, ok := os.LookupEnv(s.env); ok && value != "" {
+			if err := s.set(value); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", s.env, err))
+			}
+		}
+	}
+	flags.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.path == f.Name {
+				if err := s.set(f.Value.String()); err != nil {
+					problems = append(problems, fmt.Sprintf("-%s: %v", s.path, err))
+				}
+			}
+		}
+	})
+
+	problems = append(problems, config.validate()...)
+	if len(problems) > 0 {
+		return Config{}, fmt.Errorf("config: %s", strings.Join(problems, "; "))
+	}
+	return config, nil
+}
+
+// indexName is what every backend accepts as an index name.
+var indexName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+func (c Config) validate() []string {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(c.Server.Port > 0 && c.Server.Port < 65536, "server.port: %d is not a port", c.Server.Port)
+	for name, timeout := range map[string]time.Duration{
+		"server.read_header_timeout": c.Server.ReadHeaderTimeout,
+		"server.read_timeout":        c.Server.ReadTimeout,
+		"server.write_timeout":       c.Server.WriteTimeout,
+		"server.idle_timeout":        c.Server.IdleTimeout,
+		"server.shutdown_timeout":    c.Server.ShutdownTimeout,
+	} {
+		check(timeout > 0, "%s: must be positive", name)
+	}
+
+	problems = append(problems, validateCORS("cors", c.CORS)...)
+	for pattern := range c.CORS.Routes {
+		_, err := path.Match(pattern, "/")
+		check(err == nil, "cors.routes: %q is not a valid path pattern", pattern)
+		problems = append(problems, validateCORS("cors.routes."+pattern, c.CORS.Route(pattern))...)
+	}
+
+	switch c.Storage.Backend {
+	case "zincsearch", "elasticsearch", "opensearch":
+		check(c.Storage.URL != "", "storage.url: required by the %s backend", c.Storage.Backend)
+	case "embedded":
+	default:
+		check(false, "storage.backend: unknown backend %q", c.Storage.Backend)
+	}
+
+	check(indexName.MatchString(c.Indices.Logs), "indices.logs: %q is not a valid index name", c.Indices.Logs)
+	check(indexName.MatchString(c.Indices.Transactions), "indices.transactions: %q is not a valid index name", c.Indices.Transactions)
+	check(c.Indices.Logs != c.Indices.Transactions, "indices: logs and transactions need different indices")
+	check(c.Indices.Logs != auth.Index && c.Indices.Transactions != auth.Index, "indices: %q holds the API keys", auth.Index)
+	check(!tenant.Collide(c.Indices.Logs, c.Indices.Transactions),
+		"indices: %q and %q would share indices across tenants", c.Indices.Logs, c.Indices.Transactions)
+
+	var level slog.Level
+	check(level.UnmarshalText([]byte(c.Log.Level)) == nil, "log.level: unknown level %q", c.Log.Level)
+	check(c.Log.Format == "json" || c.Log.Format == "text", "log.format: %q is not json or text", c.Log.Format)
+
+	switch c.Tracing.Exporter {
+	case "none", "stdout":
+	case "otlp":
+		endpoint, err := url.Parse(c.Tracing.Endpoint)
+		check(err == nil && (endpoint.Scheme == "http" || endpoint.Scheme == "https") && endpoint.Host != "",
+			"tracing.endpoint: %q is not an http or https URL", c.Tracing.Endpoint)
+	case "file":
+		check(c.Tracing.File != "", "tracing.file: required by the file exporter")
+	default:
+		check(false, "tracing.exporter: unknown exporter %q", c.Tracing.Exporter)
+	}
+	check(c.Tracing.ServiceName != "", "tracing.service_name: required")
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "tracing.sample_ratio: must be between 0 and 1")
+
+	if c.Ingest.Queue {
+		check(c.Ingest.WALFile != "", "ingest.wal_file: required with ingest.queue")
+		check(c.Ingest.BatchSize > 0, "ingest.batch_size: must be positive")
+		check(c.Ingest.FlushInterval > 0, "ingest.flush_interval: must be positive")
+		check(c.Ingest.MaxPending > 0, "ingest.max_pending: must be positive")
+	}
+	return problems
+}
+
+func validateCORS(name string, policy CORS) []string {
+	var problems []string
+	if len(policy.AllowedOrigins) == 0 {
+		problems = append(problems, name+".allowed_origins: at least one origin is required")
+	}
+	for _, origin := range policy.AllowedOrigins {
+		if _, err := path.Match(origin, ""); err != nil {
+			problems = append(problems, fmt.Sprintf("%s.allowed_origins: %q is not a valid pattern", name, origin))
+		}
+		// Browsers refuse credentials with a wildcard origin, and echoing any origin instead would
+		// let every site make credentialed requests.
+		if origin == "*" && policy.AllowCredentials {
+			problems = append(problems, name+".allow_credentials: needs explicit origins, not \"*\"")
+		}
+	}
+	if policy.MaxAge < 0 {
+		problems = append(problems, name+".max_age: must not be negative")
+	}
+	return problems
+}
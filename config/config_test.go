@@ -0,0 +1,38 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The -config default comes from CONFIG_FILE, which .env may set.
+func TestLoadReadsConfigFileFromDotEnv(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, ".env"), []byte("CONFIG_FILE=server.yaml\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "server.yaml"), []byte("server:\n  port: 9123\nstorage:\n  backend: embedded\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+	// godotenv leaves variables that are set alone, and sets the others for good.
+	t.Setenv("CONFIG_FILE", "")
+	os.Unsetenv("CONFIG_FILE")
+
+	config, err := Load(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Server.Port != 9123 {
+		t.Errorf("port = %d, want the one of server.yaml", config.Server.Port)
+	}
+}
@@ -0,0 +1,126 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// setting binds a field of Config to its environment variable and its flag, named after the YAML
+// path of the field.
+type setting struct {
+	path  string
+	env   string
+	usage string
+	set   func(value string) error
+}
+
+func (c *Config) settings() []setting {
+	return []setting{
+		intSetting("server.port", "PORT", "port to listen on", &c.Server.Port),
+		durationSetting("server.read_header_timeout", "HTTP_READ_HEADER_TIMEOUT", "time to read request headers", &c.Server.ReadHeaderTimeout),
+		durationSetting("server.read_timeout", "HTTP_READ_TIMEOUT", "time to read a whole request", &c.Server.ReadTimeout),
+		durationSetting("server.write_timeout", "HTTP_WRITE_TIMEOUT", "time to write a response", &c.Server.WriteTimeout),
+		durationSetting("server.idle_timeout", "HTTP_IDLE_TIMEOUT", "time to keep idle connections open", &c.Server.IdleTimeout),
+		durationSetting("server.shutdown_timeout", "SHUTDOWN_TIMEOUT", "time to drain requests on shutdown", &c.Server.ShutdownTimeout),
+		listSetting("cors.allowed_origins", "CORS_ALLOWED_ORIGINS", "comma separated origins or origin patterns allowed by CORS", &c.CORS.AllowedOrigins),
+		listSetting("cors.allowed_methods", "CORS_ALLOWED_METHODS", "comma separated methods allowed by CORS", &c.CORS.AllowedMethods),
+		listSetting("cors.allowed_headers", "CORS_ALLOWED_HEADERS", "comma separated request headers allowed by CORS", &c.CORS.AllowedHeaders),
+		listSetting("cors.exposed_headers", "CORS_EXPOSED_HEADERS", "comma separated response headers exposed by CORS", &c.CORS.ExposedHeaders),
+		boolSetting("cors.allow_credentials", "CORS_ALLOW_CREDENTIALS", "allow credentialed CORS requests", &c.CORS.AllowCredentials),
+		durationSetting("cors.max_age", "CORS_MAX_AGE", "time browsers may cache preflight answers", &c.CORS.MaxAge),
+		stringSetting("storage.backend", "STORAGE_BACKEND", "zincsearch, elasticsearch, opensearch or embedded", &c.Storage.Backend),
+		stringSetting("storage.url", "ELASTICSEARCH_URL", "URL of the storage backend", &c.Storage.URL),
+		stringSetting("storage.username", "ELASTICSEARCH_USERNAME", "user of the storage backend", &c.Storage.Username),
+		stringSetting("storage.password", "ELASTICSEARCH_PASSWORD", "password of the storage backend", &c.Storage.Password),
+		stringSetting("storage.embedded_file", "EMBEDDED_DATA_FILE", "file persisting the embedded backend", &c.Storage.EmbeddedFile),
+		stringSetting("indices.logs", "LOGS_INDEX", "index of the logs", &c.Indices.Logs),
+		stringSetting("indices.transactions", "TRANSACTIONS_INDEX", "index of the transactions", &c.Indices.Transactions),
+		boolSetting("ingest.queue", "INGEST_QUEUE", "queue writes in a write-ahead log", &c.Ingest.Queue),
+		stringSetting("ingest.wal_file", "INGEST_WAL_FILE", "write-ahead log of the ingest queue", &c.Ingest.WALFile),
+		intSetting("ingest.batch_size", "INGEST_BATCH_SIZE", "documents written per batch", &c.Ingest.BatchSize),
+		durationSetting("ingest.flush_interval", "INGEST_FLUSH_INTERVAL", "time between batches", &c.Ingest.FlushInterval),
+		intSetting("ingest.max_pending", "INGEST_MAX_PENDING", "documents queued before writes are refused", &c.Ingest.MaxPending),
+		stringSetting("auth.keys_file", "API_KEYS_FILE", "file of bootstrap API keys", &c.Auth.KeysFile),
+		stringSetting("auth.policy_file", "RBAC_POLICY_FILE", "file of the role policy", &c.Auth.PolicyFile),
+		stringSetting("auth.jwt.secret", "JWT_SECRET", "HMAC secret of bearer tokens", &c.Auth.JWT.Secret),
+		stringSetting("auth.jwt.jwks_file", "JWT_JWKS_FILE", "JWKS file of bearer token keys", &c.Auth.JWT.JWKSFile),
+		stringSetting("auth.jwt.issuer", "JWT_ISSUER", "required issuer of bearer tokens", &c.Auth.JWT.Issuer),
+		stringSetting("auth.jwt.audience", "JWT_AUDIENCE", "required audience of bearer tokens", &c.Auth.JWT.Audience),
+		listSetting("auth.jwt.default_scopes", "JWT_DEFAULT_SCOPES", "comma separated scopes of tokens without a scope claim", &c.Auth.JWT.DefaultScopes),
+		boolSetting("tenants.required", "TENANT_REQUIRED", "refuse requests without a tenant", &c.Tenants.Required),
+		stringSetting("rate_limit.file", "RATE_LIMIT_FILE", "file of rate limits and quotas", &c.RateLimit.File),
+		stringSetting("log.level", "LOG_LEVEL", "debug, info, warn or error", &c.Log.Level),
+		stringSetting("log.format", "LOG_FORMAT", "json or text", &c.Log.Format),
+		stringSetting("tracing.exporter", "TRACING_EXPORTER", "none, otlp, stdout or file", &c.Tracing.Exporter),
+		stringSetting("tracing.endpoint", "OTEL_EXPORTER_OTLP_ENDPOINT", "URL of the OTLP/HTTP collector", &c.Tracing.Endpoint),
+		stringSetting("tracing.file", "TRACING_FILE", "file spans are written to by the file exporter", &c.Tracing.File),
+		stringSetting("tracing.service_name", "OTEL_SERVICE_NAME", "service name of the spans", &c.Tracing.ServiceName),
+		floatSetting("tracing.sample_ratio", "TRACING_SAMPLE_RATIO", "share of new traces recorded, from 0 to 1", &c.Tracing.SampleRatio),
+	}
+}
+
+func stringSetting(path, env, usage string, field *string) setting {
+	return setting{path, env, usage, func(value string) error {
+		*field = value
+		return nil
+	}}
+}
+
+func intSetting(path, env, usage string, field *int) setting {
+	return setting{path, env, usage, func(value string) error {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+		*field = n
+		return nil
+	}}
+}
+
+func floatSetting(path, env, usage string, field *float64) setting {
+	return setting{path, env, usage, func(value string) error {
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+		*field = f
+		return nil
+	}}
+}
+
+func boolSetting(path, env, usage string, field *bool) setting {
+	return setting{path, env, usage, func(value string) error {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not true or false", value)
+		}
+		*field = b
+		return nil
+	}}
+}
+
+func durationSetting(path, env, usage string, field *time.Duration) setting {
+	return setting{path, env, usage, func(value string) error {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration like 30s", value)
+		}
+		*field = d
+		return nil
+	}}
+}
+
+func listSetting(path, env, usage string, field *[]string) setting {
+	return setting{path, env, usage, func(value string) error {
+		var list []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		*field = list
+		return nil
+	}}
+}
@@ -0,0 +1,314 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sofa-logs-servers/infra/storage"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Index is where keys created through the API are kept.
+const Index = "api_keys"
+
+// Header carries the API key of a request.
+const Header = "X-API-Key"
+
+const (
+	// cacheTTL bounds how long a key read from the backend is trusted without reading it again.
+	cacheTTL = time.Minute
+	// missTTL is how long an unknown key id is answered without asking the backend, so a flood
+	// of made up keys does not turn into a flood of reads.
+	missTTL = 10 * time.Second
+	// maxCached bounds the cache, which made up key ids would otherwise grow without end.
+	maxCached = 10000
+)
+
+var (
+	ErrInvalidKey = errors.New("invalid api key")
+	// ErrFileKey is returned when revoking a key that comes from the key file.
+	ErrFileKey = errors.New("key is defined in the key file")
+)
+
+// Scope names a role of the access policy. See Policy for what each role may do.
+type Scope string
+
+const (
+	ScopeIngest Scope = "ingest"
+	ScopeRead   Scope = "read"
+	ScopeAdmin  Scope = "admin"
+)
+
+var KeyMapping = storage.Mapping{
+	"name":       storage.FieldKeyword,
+	"hash":       storage.FieldKeyword,
+	"scopes":     storage.FieldKeyword,
+	"tenant":     storage.FieldKeyword,
+	"created_at": storage.FieldDate,
+	"revoked_at": storage.FieldDate,
+}
+
+// Key is an API key as it is stored. The key handed to clients is "<ID>.<secret>" and only the
+// SHA-256 of the secret is kept.
+type Key struct {
+	ID     string  `json:"id,omitempty"`
+	Name   string  `json:"name"`
+	Hash   string  `json:"hash"`
+	Scopes []Scope `json:"scopes"`
+	// Tenant binds the key to the indices of one tenant. Keys without one pick the tenant per request.
+	Tenant    string     `json:"tenant,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Principal is who a request authenticated as, with an API key or a token.
+type Principal struct {
+	// ID names the API key or the token issuer and subject.
+	ID string
+	// Subject is the subject of a token, empty for API keys.
+	Subject string
+	Scopes  []Scope
+	// Tenant is the tenant the credentials are bound to, if any.
+	Tenant string
+}
+
+// HasScope reports whether the credentials were granted scope.
+func (p Principal) HasScope(scope Scope) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func (k Key) Principal() Principal {
+	return Principal{ID: "key:" + k.ID, Scopes: k.Scopes, Tenant: k.Tenant}
+}
+
+type cachedKey struct {
+	key Key
+	// missing marks a key id the backend does not know.
+	missing bool
+	expires time.Time
+}
+
+// KeyStore checks API keys against the key file and the keys created through the API.
+type KeyStore struct {
+	store storage.Storage
+	file  map[string]Key
+
+	mu    sync.Mutex
+	cache map[string]cachedKey
+}
+
+// NewKeyStore keeps new keys in store. fileKeys are accepted as well but cannot be revoked.
+func NewKeyStore(store storage.Storage, fileKeys []Key) *KeyStore {
+	k := &KeyStore{store: store, file: map[string]Key{}, cache: map[string]cachedKey{}}
+	for _, key := range fileKeys {
+		k.file[key.ID] = key
+	}
+	return k
+}
+
+// Init creates the key index and loads the key file at keysFile, if any.
+func Init(store storage.Storage, keysFile string) (*KeyStore, error) {
+	err := store.EnsureIndex(context.Background(), Index, KeyMapping)
+	if err != nil {
+		return nil, err
+	}
+
+	var fileKeys []Key
+	if keysFile != "" {
+		fileKeys, err = ReadKeyFile(keysFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return NewKeyStore(store, fileKeys), nil
+}
+
+// ReadKeyFile reads a JSON array of keys, as printed by cmd/apikey.
+func ReadKeyFile(path string) ([]Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []Key
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("auth: %s: %w", path, err)
+	}
+	for _, key := range keys {
+		if key.ID == "" || key.Hash == "" {
+			return nil, fmt.Errorf("auth: %s: every key needs an id and a hash", path)
+		}
+	}
+	return keys, nil
+}
+
+// Hash returns the form a key secret is stored in.
+func Hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewSecret returns a random key secret.
+func NewSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// Authenticate returns the key raw belongs to. It fails with ErrInvalidKey when the key is
+// unknown, revoked or has the wrong secret.
+func (k *KeyStore) Authenticate(ctx context.Context, raw string) (Key, error) {
+	id, secret, ok := strings.Cut(raw, ".")
+	if !ok || id == "" || secret == "" {
+		return Key{}, ErrInvalidKey
+	}
+
+	key, err := k.lookup(ctx, id)
+	if err != nil {
+		return Key{}, err
+	}
+
+	if key.RevokedAt != nil || subtle.ConstantTimeCompare([]byte(key.Hash), []byte(Hash(secret))) != 1 {
+		return Key{}, ErrInvalidKey
+	}
+	return key, nil
+}
+
+func (k *KeyStore) lookup(ctx context.Context, id string) (Key, error) {
+	if key, ok := k.file[id]; ok {
+		return key, nil
+	}
+
+	k.mu.Lock()
+	cached, ok := k.cache[id]
+	k.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		if cached.missing {
+			return Key{}, ErrInvalidKey
+		}
+		return cached.key, nil
+	}
+
+	hit, err := k.store.Get(ctx, Index, id)
+	if errors.Is(err, storage.ErrNotFound) {
+		k.remember(id, cachedKey{missing: true, expires: time.Now().Add(missTTL)})
+		return Key{}, ErrInvalidKey
+	}
+	if err != nil {
+		return Key{}, err
+	}
+
+	key := Key{}
+	if err := json.Unmarshal(hit.Source, &key); err != nil {
+		return Key{}, err
+	}
+	key.ID = hit.ID
+
+	k.remember(id, cachedKey{key: key, expires: time.Now().Add(cacheTTL)})
+	return key, nil
+}
+
+// remember caches the lookup of id. Once the cache is full expired entries are dropped, and
+// while it stays full nothing more is cached.
+func (k *KeyStore) remember(id string, cached cachedKey) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if len(k.cache) >= maxCached {
+		now := time.Now()
+		for cachedID, c := range k.cache {
+			if now.After(c.expires) {
+				delete(k.cache, cachedID)
+			}
+		}
+		if len(k.cache) >= maxCached {
+			return
+		}
+	}
+	k.cache[id] = cached
+}
+
+// Create stores a new key and returns it together with the only copy of the raw key.
+func (k *KeyStore) Create(ctx context.Context, name string, scopes []Scope, tenant string) (Key, string, error) {
+	secret, err := NewSecret()
+	if err != nil {
+		return Key{}, "", err
+	}
+
+	key := Key{Name: name, Hash: Hash(secret), Scopes: scopes, Tenant: tenant, CreatedAt: time.Now()}
+	res, err := k.store.Index(ctx, Index, key)
+	if err != nil {
+		return Key{}, "", err
+	}
+
+	key.ID = res.ID
+	k.mu.Lock()
+	delete(k.cache, key.ID)
+	k.mu.Unlock()
+	return key, key.ID + "." + secret, nil
+}
+
+// Revoke stops the key with id from being accepted. When tenant is set, only keys bound to that
+// tenant are found.
+func (k *KeyStore) Revoke(ctx context.Context, id, tenant string) error {
+	if _, ok := k.file[id]; ok {
+		return ErrFileKey
+	}
+
+	hit, err := k.store.Get(ctx, Index, id)
+	if err != nil {
+		return err
+	}
+
+	key := Key{}
+	if err := json.Unmarshal(hit.Source, &key); err != nil {
+		return err
+	}
+	if tenant != "" && key.Tenant != tenant {
+		return storage.ErrNotFound
+	}
+	if key.RevokedAt != nil {
+		return nil
+	}
+
+	revokedAt := time.Now()
+	key.RevokedAt = &revokedAt
+	err = k.store.Update(ctx, Index, id, key)
+	if err != nil {
+		return err
+	}
+
+	k.mu.Lock()
+	delete(k.cache, id)
+	k.mu.Unlock()
+	return nil
+}
+
+type contextKey struct{}
+
+// WithPrincipal returns a copy of ctx that carries the principal a request authenticated as.
+func WithPrincipal(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, principal)
+}
+
+// FromContext returns the principal a request authenticated as.
+func FromContext(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(contextKey{}).(Principal)
+	return principal, ok
+}
@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sofa-logs-servers/infra/embedded"
+	"sofa-logs-servers/infra/storage"
+	"testing"
+	"time"
+)
+
+// countingStore counts the key reads that reach the backend.
+type countingStore struct {
+	storage.Storage
+	gets int
+}
+
+func (s *countingStore) Get(ctx context.Context, index, id string) (storage.Hit, error) {
+	s.gets++
+	return s.Storage.Get(ctx, index, id)
+}
+
+func newKeyStore(t *testing.T, fileKeys ...Key) (*KeyStore, *countingStore) {
+	t.Helper()
+	backend, err := embedded.Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &countingStore{Storage: backend}
+	keys, err := Init(store, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range fileKeys {
+		keys.file[key.ID] = key
+	}
+	return keys, store
+}
+
+func TestAuthenticate(t *testing.T) {
+	ctx := context.Background()
+	keys, _ := newKeyStore(t, Key{ID: "file", Hash: Hash("filesecret"), Scopes: []Scope{ScopeAdmin}})
+	created, raw, err := keys.Create(ctx, "ci", []Scope{ScopeIngest}, "acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		raw  string
+		id   string
+		err  error
+	}{
+		{"created key", raw, created.ID, nil},
+		{"file key", "file.filesecret", "file", nil},
+		{"wrong secret", created.ID + ".nope", "", ErrInvalidKey},
+		{"hash as secret", created.ID + "." + created.Hash, "", ErrInvalidKey},
+		{"unknown id", "unknown.secret", "", ErrInvalidKey},
+		{"no secret", created.ID + ".", "", ErrInvalidKey},
+		{"no separator", created.ID, "", ErrInvalidKey},
+		{"empty", "", "", ErrInvalidKey},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			key, err := keys.Authenticate(ctx, test.raw)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("err = %v, want %v", err, test.err)
+			}
+			if key.ID != test.id {
+				t.Errorf("key id = %q, want %q", key.ID, test.id)
+			}
+		})
+	}
+
+	key, err := keys.Authenticate(ctx, raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if principal := key.Principal(); principal.Tenant != "acme" || !principal.HasScope(ScopeIngest) || principal.HasScope(ScopeAdmin) {
+		t.Errorf("principal %+v", principal)
+	}
+}
+
+// A revoked key is refused at once, although it was cached while it was valid.
+func TestRevoke(t *testing.T) {
+	ctx := context.Background()
+	keys, _ := newKeyStore(t, Key{ID: "file", Hash: Hash("filesecret")})
+	created, raw, err := keys.Create(ctx, "ci", []Scope{ScopeIngest}, "acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keys.Authenticate(ctx, raw); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := keys.Revoke(ctx, created.ID, "other"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("revoking from another tenant: err = %v", err)
+	}
+	if err := keys.Revoke(ctx, "file", ""); !errors.Is(err, ErrFileKey) {
+		t.Errorf("revoking a file key: err = %v", err)
+	}
+	if err := keys.Revoke(ctx, created.ID, "acme"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keys.Authenticate(ctx, raw); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("revoked key: err = %v", err)
+	}
+	// Revoking twice is harmless.
+	if err := keys.Revoke(ctx, created.ID, ""); err != nil {
+		t.Errorf("revoking again: err = %v", err)
+	}
+}
+
+func TestLookupCache(t *testing.T) {
+	ctx := context.Background()
+	keys, store := newKeyStore(t)
+	_, raw, err := keys.Create(ctx, "ci", []Scope{ScopeRead}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := keys.Authenticate(ctx, raw); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := keys.Authenticate(ctx, "unknown.secret"); !errors.Is(err, ErrInvalidKey) {
+			t.Fatalf("unknown key: err = %v", err)
+		}
+	}
+	if store.gets != 2 {
+		t.Errorf("%d reads for a known and an unknown key, want 2", store.gets)
+	}
+}
+
+func TestCacheIsBounded(t *testing.T) {
+	keys, _ := newKeyStore(t)
+	expired := cachedKey{missing: true, expires: time.Now().Add(-time.Second)}
+	live := cachedKey{missing: true, expires: time.Now().Add(time.Minute)}
+
+	for i := 0; i < maxCached; i++ {
+		keys.remember(fmt.Sprint("live", i), live)
+	}
+	keys.remember("one more", live)
+	if _, ok := keys.cache["one more"]; ok || len(keys.cache) != maxCached {
+		t.Errorf("cache grew to %d entries past its bound", len(keys.cache))
+	}
+
+	// Expired entries make room once the cache is full.
+	for id := range keys.cache {
+		keys.cache[id] = expired
+	}
+	keys.remember("one more", live)
+	if _, ok := keys.cache["one more"]; !ok || len(keys.cache) != 1 {
+		t.Errorf("cache holds %d entries after dropping the expired ones", len(keys.cache))
+	}
+}
@@ -0,0 +1,32 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+var ErrNoCredentials = errors.New("no credentials")
+
+// Authenticator checks the credentials of a request: an API key in the X-API-Key header or, when
+// Tokens is set, a bearer token in the Authorization header.
+type Authenticator struct {
+	Keys   *KeyStore
+	Tokens *TokenVerifier
+}
+
+func (a Authenticator) Authenticate(r *http.Request) (Principal, error) {
+	if raw := r.Header.Get(Header); raw != "" {
+		key, err := a.Keys.Authenticate(r.Context(), raw)
+		if err != nil {
+			return Principal{}, err
+		}
+		return key.Principal(), nil
+	}
+
+	authorization := r.Header.Get("Authorization")
+	if a.Tokens != nil && len(authorization) > len("Bearer ") && strings.EqualFold(authorization[:len("Bearer ")], "Bearer ") {
+		return a.Tokens.Verify(authorization[len("Bearer "):])
+	}
+	return Principal{}, ErrNoCredentials
+}
@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+)
+
+type Operation string
+
+const (
+	OpCreate Operation = "create"
+	OpRead   Operation = "read"
+	OpUpdate Operation = "update"
+	OpDelete Operation = "delete"
+)
+
+// Rule allows Operations on Indices. Both may hold "*" and index names may be glob patterns.
+type Rule struct {
+	Indices    []string    `json:"indices"`
+	Operations []Operation `json:"operations"`
+}
+
+// Policy lists what each role may do. The scopes of an API key or token are the roles it holds,
+// and a request is allowed when a rule of any of them allows it.
+type Policy struct {
+	Roles map[Scope][]Rule `json:"roles"`
+}
+
+// DefaultPolicy is used without a policy file: ingest clients may only create
+// documents, readers may only read them and admins may do anything, managing keys included.
+var DefaultPolicy = Policy{Roles: map[Scope][]Rule{
+	ScopeIngest: {{Indices: []string{"requests", "transactions"}, Operations: []Operation{OpCreate}}},
+	ScopeRead:   {{Indices: []string{"requests", "transactions"}, Operations: []Operation{OpRead}}},
+	ScopeAdmin:  {{Indices: []string{"*"}, Operations: []Operation{"*"}}},
+}}
+
+// InitPolicy reads the policy file at path, or returns DefaultPolicy when path is empty.
+func InitPolicy(path string) (Policy, error) {
+	if path != "" {
+		return ReadPolicy(path)
+	}
+	return DefaultPolicy, nil
+}
+
+// ReadPolicy reads a JSON policy file, like
+//
+//	{"roles": {"analyst": [{"indices": ["requests"], "operations": ["read"]}]}}
+func ReadPolicy(file string) (Policy, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return Policy{}, err
+	}
+
+	policy := Policy{}
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return Policy{}, fmt.Errorf("auth: %s: %w", file, err)
+	}
+
+	for role, rules := range policy.Roles {
+		for _, rule := range rules {
+			for _, pattern := range rule.Indices {
+				if _, err := path.Match(pattern, ""); err != nil {
+					return Policy{}, fmt.Errorf("auth: %s: role %s: index pattern %q: %w", file, role, pattern, err)
+				}
+			}
+		}
+	}
+	return policy, nil
+}
+
+// HasRole reports whether the policy defines role.
+func (p Policy) HasRole(role Scope) bool {
+	_, ok := p.Roles[role]
+	return ok
+}
+
+// Allows reports whether any of roles may perform op on index.
+func (p Policy) Allows(roles []Scope, index string, op Operation) bool {
+	for _, role := range roles {
+		for _, rule := range p.Roles[role] {
+			if rule.allows(index, op) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (r Rule) allows(index string, op Operation) bool {
+	opAllowed := false
+	for _, allowed := range r.Operations {
+		opAllowed = opAllowed || allowed == op || allowed == "*"
+	}
+	if !opAllowed {
+		return false
+	}
+
+	for _, pattern := range r.Indices {
+		if matched, _ := path.Match(pattern, index); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAllows(t *testing.T) {
+	policy := Policy{Roles: map[Scope][]Rule{
+		ScopeIngest: DefaultPolicy.Roles[ScopeIngest],
+		ScopeRead:   DefaultPolicy.Roles[ScopeRead],
+		ScopeAdmin:  DefaultPolicy.Roles[ScopeAdmin],
+		"analyst": {
+			{Indices: []string{"req*"}, Operations: []Operation{OpRead}},
+			{Indices: []string{"transactions"}, Operations: []Operation{"*"}},
+		},
+	}}
+
+	tests := []struct {
+		name  string
+		roles []Scope
+		index string
+		op    Operation
+		want  bool
+	}{
+		{"ingest creates", []Scope{ScopeIngest}, "requests", OpCreate, true},
+		{"ingest cannot read", []Scope{ScopeIngest}, "requests", OpRead, false},
+		{"ingest cannot touch keys", []Scope{ScopeIngest}, Index, OpCreate, false},
+		{"read reads", []Scope{ScopeRead}, "transactions", OpRead, true},
+		{"read cannot delete", []Scope{ScopeRead}, "transactions", OpDelete, false},
+		{"any role suffices", []Scope{ScopeIngest, ScopeRead}, "requests", OpRead, true},
+		{"admin manages keys", []Scope{ScopeAdmin}, Index, OpDelete, true},
+		{"glob index", []Scope{"analyst"}, "requests", OpRead, true},
+		{"glob does not widen the operation", []Scope{"analyst"}, "requests", OpUpdate, false},
+		{"any operation", []Scope{"analyst"}, "transactions", OpDelete, true},
+		{"unmatched index", []Scope{"analyst"}, Index, OpRead, false},
+		{"unknown role", []Scope{"nobody"}, "requests", OpRead, false},
+		{"no roles", nil, "requests", OpRead, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := policy.Allows(test.roles, test.index, test.op); got != test.want {
+				t.Errorf("Allows(%v, %s, %s) = %v, want %v", test.roles, test.index, test.op, got, test.want)
+			}
+		})
+	}
+}
+
+func TestReadPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		ok      bool
+	}{
+		{"valid", `{"roles":{"analyst":[{"indices":["req*"],"operations":["read"]}]}}`, true},
+		{"bad pattern", `{"roles":{"analyst":[{"indices":["req["],"operations":["read"]}]}}`, false},
+		{"not json", `roles: {}`, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "policy.json")
+			if err := os.WriteFile(file, []byte(test.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			policy, err := ReadPolicy(file)
+			if (err == nil) != test.ok {
+				t.Fatalf("err = %v", err)
+			}
+			if test.ok && !policy.HasRole("analyst") {
+				t.Errorf("roles %v", policy.Roles)
+			}
+		})
+	}
+}
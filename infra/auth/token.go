@@ -0,0 +1,229 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var ErrInvalidToken = errors.New("invalid token")
+
+type TokenConfig struct {
+	// Secret verifies HS256, HS384 and HS512 tokens.
+	Secret []byte
+	// JWKSPath is a JSON Web Key Set file whose keys verify RSA, ECDSA and EdDSA tokens.
+	JWKSPath string
+	// Issuer and Audience are checked when set.
+	Issuer   string
+	Audience string
+	// DefaultScopes are granted to tokens without a scope claim.
+	DefaultScopes []Scope
+}
+
+// TokenVerifier checks JWT bearer tokens. The subject of a token is the user it was issued to.
+type TokenVerifier struct {
+	config  TokenConfig
+	keys    map[string]interface{}
+	methods []string
+}
+
+type tokenClaims struct {
+	jwt.RegisteredClaims
+	// Scope lists scopes separated by spaces, as in OAuth 2.0.
+	Scope string `json:"scope"`
+	// Tenant binds the token to the indices of one tenant.
+	Tenant string `json:"tenant"`
+}
+
+// jsonWebKey holds the members of a JWK this server understands.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func NewTokenVerifier(config TokenConfig) (*TokenVerifier, error) {
+	v := &TokenVerifier{config: config, keys: map[string]interface{}{}}
+
+	if len(config.Secret) > 0 {
+		v.methods = append(v.methods, "HS256", "HS384", "HS512")
+	}
+
+	if config.JWKSPath != "" {
+		err := v.readJWKS(config.JWKSPath)
+		if err != nil {
+			return nil, err
+		}
+		v.methods = append(v.methods, "RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA")
+	}
+
+	if len(v.methods) == 0 {
+		return nil, errors.New("auth: tokens need a secret or a JWKS file")
+	}
+	return v, nil
+}
+
+// InitTokens configures token verification. It returns nil when config has neither a secret nor
+// a JWKS file, which leaves tokens disabled.
+func InitTokens(config TokenConfig) (*TokenVerifier, error) {
+	if len(config.Secret) == 0 && config.JWKSPath == "" {
+		return nil, nil
+	}
+	return NewTokenVerifier(config)
+}
+
+func (v *TokenVerifier) readJWKS(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	set := struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return fmt.Errorf("auth: %s: %w", path, err)
+	}
+
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		key, err := jwk.publicKey()
+		if err != nil {
+			return fmt.Errorf("auth: %s: key %q: %w", path, jwk.Kid, err)
+		}
+		v.keys[jwk.Kid] = key
+	}
+
+	if len(v.keys) == 0 {
+		return fmt.Errorf("auth: %s has no signing keys", path)
+	}
+	return nil
+}
+
+func (jwk jsonWebKey) publicKey() (interface{}, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+
+		x, err := decodeInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("bad Ed25519 public key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", jwk.Kty)
+}
+
+func decodeInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+// key picks the key a token was signed with: the secret for HMAC, otherwise the JWKS key named by
+// the kid header, which may be left out when the set has a single key.
+func (v *TokenVerifier) key(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+		return v.config.Secret, nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	if key, ok := v.keys[kid]; ok {
+		return key, nil
+	}
+	if kid == "" && len(v.keys) == 1 {
+		for _, key := range v.keys {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("no key with kid %q", kid)
+}
+
+// Verify checks the signature and claims of raw and returns the principal it was issued to.
+func (v *TokenVerifier) Verify(raw string) (Principal, error) {
+	claims := tokenClaims{}
+	parser := jwt.NewParser(jwt.WithValidMethods(v.methods))
+	if _, err := parser.ParseWithClaims(raw, &claims, v.key); err != nil {
+		return Principal{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	if claims.Subject == "" {
+		return Principal{}, fmt.Errorf("%w: no subject", ErrInvalidToken)
+	}
+	if v.config.Issuer != "" && !claims.VerifyIssuer(v.config.Issuer, true) {
+		return Principal{}, fmt.Errorf("%w: wrong issuer", ErrInvalidToken)
+	}
+	if v.config.Audience != "" && !claims.VerifyAudience(v.config.Audience, true) {
+		return Principal{}, fmt.Errorf("%w: wrong audience", ErrInvalidToken)
+	}
+
+	scopes := parseScopes(claims.Scope)
+	if claims.Scope == "" {
+		scopes = v.config.DefaultScopes
+	}
+	return Principal{ID: "token:" + claims.Issuer + "/" + claims.Subject, Subject: claims.Subject, Scopes: scopes, Tenant: claims.Tenant}, nil
+}
+
+// parseScopes reads a space separated scope list. Scopes the policy has no role for grant nothing.
+func parseScopes(list string) []Scope {
+	var scopes []Scope
+	for _, name := range strings.Fields(list) {
+		scopes = append(scopes, Scope(name))
+	}
+	return scopes
+}
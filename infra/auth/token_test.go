@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var secret = []byte("test secret")
+
+func claims(edit func(*tokenClaims)) tokenClaims {
+	c := tokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "7",
+			Issuer:    "https://issuer.example",
+			Audience:  jwt.ClaimStrings{"logs"},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+		Scope: "ingest read",
+	}
+	if edit != nil {
+		edit(&c)
+	}
+	return c
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, key interface{}, kid string, c tokenClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, c)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	raw, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+// writeJWKS writes the public halves of keys, by kid, to a JWKS file.
+func writeJWKS(t *testing.T, keys map[string]*rsa.PrivateKey) string {
+	t.Helper()
+	encode := func(n *big.Int) string { return base64.RawURLEncoding.EncodeToString(n.Bytes()) }
+
+	set := struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{}
+	for kid, key := range keys {
+		set.Keys = append(set.Keys, jsonWebKey{Kty: "RSA", Kid: kid, Use: "sig", N: encode(key.N), E: encode(big.NewInt(int64(key.E)))})
+	}
+	raw, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, raw, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func rsaKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestVerify(t *testing.T) {
+	signing, other := rsaKey(t), rsaKey(t)
+	verifier, err := NewTokenVerifier(TokenConfig{
+		Secret:        secret,
+		JWKSPath:      writeJWKS(t, map[string]*rsa.PrivateKey{"a": signing, "b": other}),
+		Issuer:        "https://issuer.example",
+		Audience:      "logs",
+		DefaultScopes: []Scope{ScopeIngest},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	none, err := jwt.NewWithClaims(jwt.SigningMethodNone, claims(nil)).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		raw    string
+		scopes []Scope
+		ok     bool
+	}{
+		{"hmac", sign(t, jwt.SigningMethodHS256, secret, "", claims(nil)), []Scope{ScopeIngest, ScopeRead}, true},
+		{"rsa", sign(t, jwt.SigningMethodRS256, signing, "a", claims(nil)), []Scope{ScopeIngest, ScopeRead}, true},
+		{"default scopes", sign(t, jwt.SigningMethodHS256, secret, "", claims(func(c *tokenClaims) { c.Scope = "" })), []Scope{ScopeIngest}, true},
+		{"alg none", none, nil, false},
+		{"wrong secret", sign(t, jwt.SigningMethodHS256, []byte("other"), "", claims(nil)), nil, false},
+		{"wrong kid", sign(t, jwt.SigningMethodRS256, signing, "b", claims(nil)), nil, false},
+		{"unknown kid", sign(t, jwt.SigningMethodRS256, signing, "c", claims(nil)), nil, false},
+		{"no kid among several keys", sign(t, jwt.SigningMethodRS256, signing, "", claims(nil)), nil, false},
+		{"wrong issuer", sign(t, jwt.SigningMethodHS256, secret, "", claims(func(c *tokenClaims) { c.Issuer = "https://evil.example" })), nil, false},
+		{"wrong audience", sign(t, jwt.SigningMethodHS256, secret, "", claims(func(c *tokenClaims) { c.Audience = jwt.ClaimStrings{"billing"} })), nil, false},
+		{"expired", sign(t, jwt.SigningMethodHS256, secret, "", claims(func(c *tokenClaims) { c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute)) })), nil, false},
+		{"no subject", sign(t, jwt.SigningMethodHS256, secret, "", claims(func(c *tokenClaims) { c.Subject = "" })), nil, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			principal, err := verifier.Verify(test.raw)
+			if !test.ok {
+				if !errors.Is(err, ErrInvalidToken) {
+					t.Errorf("err = %v, want ErrInvalidToken", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if principal.Subject != "7" || principal.ID != "token:https://issuer.example/7" {
+				t.Errorf("principal %+v", principal)
+			}
+			if len(principal.Scopes) != len(test.scopes) {
+				t.Fatalf("scopes = %v, want %v", principal.Scopes, test.scopes)
+			}
+			for i := range test.scopes {
+				if principal.Scopes[i] != test.scopes[i] {
+					t.Errorf("scopes = %v, want %v", principal.Scopes, test.scopes)
+				}
+			}
+		})
+	}
+}
+
+// An HMAC-only verifier refuses asymmetric algorithms, and an RSA public key never doubles as an
+// HMAC secret.
+func TestVerifyRestrictsAlgorithms(t *testing.T) {
+	signing := rsaKey(t)
+	hmacOnly, err := NewTokenVerifier(TokenConfig{Secret: secret})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := hmacOnly.Verify(sign(t, jwt.SigningMethodRS256, signing, "", claims(nil))); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("RS256 with a secret only: err = %v", err)
+	}
+
+	rsaOnly, err := NewTokenVerifier(TokenConfig{JWKSPath: writeJWKS(t, map[string]*rsa.PrivateKey{"a": signing})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, err := json.Marshal(signing.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rsaOnly.Verify(sign(t, jwt.SigningMethodHS256, publicKey, "a", claims(nil))); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("HS256 against a JWKS: err = %v", err)
+	}
+	// A set with one key does not need the kid header.
+	if _, err := rsaOnly.Verify(sign(t, jwt.SigningMethodRS256, signing, "", claims(nil))); err != nil {
+		t.Errorf("single key without kid: err = %v", err)
+	}
+}
@@ -0,0 +1,476 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sofa-logs-servers/infra/logging"
+	"sofa-logs-servers/infra/storage"
+	"sofa-logs-servers/infra/tracing"
+	"time"
+
+	"github.com/elastic/elastic-transport-go/v8/elastictransport"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+	"github.com/google/uuid"
+	jsoniter "github.com/json-iterator/go"
+	"go.opentelemetry.io/otel"
+)
+
+var _ storage.Storage = ElasticClient{}
+
+// idField repeats the document id inside the source. Sorting on _id is disabled by default, so this
+// keyword field is the tie-breaker that makes search_after pagination stable.
+const idField = "doc_id"
+
+var tracer = otel.Tracer("sofa-logs-servers/infra/elasticsearch")
+
+// ElasticClient talks to Elasticsearch or OpenSearch through the plain transport, which skips the
+// product check of the official client so both servers are accepted.
+type ElasticClient struct {
+	Transport esapi.Transport
+	// Logger stands in for the request logger when fail has none to report to.
+	Logger *slog.Logger
+}
+
+type hit struct {
+	Index  string              `json:"_index"`
+	Id     string              `json:"_id"`
+	Score  *float64            `json:"_score"`
+	Source jsoniter.RawMessage `json:"_source"`
+	Sort   []interface{}       `json:"sort"`
+}
+
+type searchResponse struct {
+	Aggregations map[string]json.RawMessage `json:"aggregations"`
+	Hits         struct {
+		Total struct {
+			Value int `json:"value"`
+		} `json:"total"`
+		Hits []hit `json:"hits"`
+	} `json:"hits"`
+}
+
+type bulkResponse struct {
+	Items []map[string]struct {
+		Id     string      `json:"_id"`
+		Result string      `json:"result"`
+		Status int         `json:"status"`
+		Error  interface{} `json:"error"`
+	} `json:"items"`
+}
+
+// NewClient creates a client for the node at url.
+func NewClient(nodeURL, username, password string, logger *slog.Logger) (ElasticClient, error) {
+	u, err := url.Parse(nodeURL)
+	if err != nil {
+		return ElasticClient{}, err
+	}
+
+	transport, err := elastictransport.New(elastictransport.Config{
+		URLs:      []*url.URL{u},
+		Username:  username,
+		Password:  password,
+		Transport: tracing.Transport(nil),
+	})
+	if err != nil {
+		return ElasticClient{}, err
+	}
+
+	return ElasticClient{Transport: transport, Logger: logger}, nil
+}
+
+// fail logs a call for operation on index that failed with status, zero without a response, and
+// returns err related to the storage errors.
+func (e ElasticClient) fail(ctx context.Context, operation, index string, status int, err error) error {
+	logger := e.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	tracing.Fail(ctx, err)
+	logging.FromContextOr(ctx, logger).Error("elasticsearch call failed",
+		"operation", operation, "index", index, "status_code", status, "err", err)
+	return storage.BackendError(status, err)
+}
+
+// do performs req, the operation on index, and decodes the response body into out when out is not
+// nil.
+func (e ElasticClient) do(ctx context.Context, operation, index string, req esapi.Request, out interface{}) error {
+	ctx, span := tracing.StartClient(ctx, tracer, "elasticsearch", operation, index)
+	defer span.End()
+
+	res, err := req.Do(ctx, e.Transport)
+	if err != nil {
+		return e.fail(ctx, operation, index, 0, err)
+	}
+
+	defer func() {
+		err := res.Body.Close()
+		if err != nil {
+			panic(err)
+		}
+	}()
+
+	if res.StatusCode == http.StatusNotFound {
+		return storage.ErrNotFound
+	}
+
+	if res.IsError() {
+		return e.fail(ctx, operation, index, res.StatusCode, fmt.Errorf("elasticsearch: %s", res.String()))
+	}
+
+	if out == nil {
+		return nil
+	}
+	return jsoniter.NewDecoder(res.Body).Decode(out)
+}
+
+func encode(body interface{}) (*bytes.Reader, error) {
+	raw, err := jsoniter.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(raw), nil
+}
+
+// toDocument converts document to a map and stamps it with its id and with @timestamp, which Zinc
+// sets on its own.
+func toDocument(id string, document interface{}) (map[string]interface{}, error) {
+	doc, err := storage.ToMap(document)
+	if err != nil {
+		return nil, err
+	}
+
+	doc[idField] = id
+	doc["@timestamp"] = time.Now()
+	return doc, nil
+}
+
+func toHit(h hit) (storage.Hit, error) {
+	meta := struct {
+		Timestamp time.Time `json:"@timestamp"`
+	}{}
+	if err := jsoniter.Unmarshal(h.Source, &meta); err != nil {
+		return storage.Hit{}, err
+	}
+
+	result := storage.Hit{
+		Index:     h.Index,
+		ID:        h.Id,
+		Timestamp: meta.Timestamp,
+		Source:    []byte(h.Source),
+		Sort:      h.Sort,
+	}
+	if h.Score != nil {
+		result.Score = *h.Score
+	}
+	return result, nil
+}
+
+func (e ElasticClient) Index(ctx context.Context, index string, document interface{}) (storage.IndexResult, error) {
+	id := uuid.NewString()
+	doc, err := toDocument(id, document)
+	if err != nil {
+		return storage.IndexResult{}, err
+	}
+
+	body, err := encode(doc)
+	if err != nil {
+		return storage.IndexResult{}, err
+	}
+
+	resp := struct {
+		Id     string `json:"_id"`
+		Result string `json:"result"`
+	}{}
+	err = e.do(ctx, "index", index, esapi.IndexRequest{Index: index, DocumentID: id, Body: body}, &resp)
+	if err != nil {
+		return storage.IndexResult{}, err
+	}
+
+	return storage.IndexResult{ID: resp.Id, Result: resp.Result}, nil
+}
+
+func (e ElasticClient) Update(ctx context.Context, index, id string, document interface{}) error {
+	doc, err := toDocument(id, document)
+	if err != nil {
+		return err
+	}
+
+	body, err := encode(doc)
+	if err != nil {
+		return err
+	}
+
+	// An update request merges into the stored document and would keep the fields the new
+	// version drops, so the document is indexed again over its id once it is known to exist.
+	err = e.do(ctx, "update", index, esapi.ExistsRequest{Index: index, DocumentID: id}, nil)
+	if err != nil {
+		return err
+	}
+	return e.do(ctx, "update", index, esapi.IndexRequest{Index: index, DocumentID: id, Body: body}, nil)
+}
+
+func (e ElasticClient) Delete(ctx context.Context, index, id string) error {
+	return e.do(ctx, "delete", index, esapi.DeleteRequest{Index: index, DocumentID: id}, nil)
+}
+
+func (e ElasticClient) Get(ctx context.Context, index, id string) (storage.Hit, error) {
+	resp := hit{}
+	err := e.do(ctx, "get", index, esapi.GetRequest{Index: index, DocumentID: id}, &resp)
+	if err != nil {
+		return storage.Hit{}, err
+	}
+
+	return toHit(resp)
+}
+
+func (e ElasticClient) Search(ctx context.Context, index string, q storage.Query) (storage.SearchResult, error) {
+	sort := make([]map[string]interface{}, 0, len(q.Sort)+1)
+	for _, s := range q.Sort {
+		order := "asc"
+		if s.Desc {
+			order = "desc"
+		}
+		sort = append(sort, map[string]interface{}{s.Field: order})
+	}
+	sort = append(sort, map[string]interface{}{idField: "asc"})
+
+	query := map[string]interface{}{
+		"query":            esFilter(q.Filter),
+		"sort":             sort,
+		"track_total_hits": true,
+	}
+
+	if q.Size > 0 {
+		query["size"] = q.Size
+	} else if len(q.Aggs) > 0 {
+		query["size"] = 0
+	}
+
+	if len(q.Aggs) > 0 {
+		query["aggs"] = esAggs(q.Aggs)
+	}
+
+	if len(q.After) > 0 {
+		query["search_after"] = q.After
+	}
+
+	body, err := encode(query)
+	if err != nil {
+		return storage.SearchResult{}, err
+	}
+
+	resp := searchResponse{}
+	err = e.do(ctx, "search", index, esapi.SearchRequest{Index: []string{index}, Body: body}, &resp)
+	if err != nil {
+		return storage.SearchResult{}, err
+	}
+
+	result := storage.SearchResult{
+		Total: resp.Hits.Total.Value,
+		Hits:  make([]storage.Hit, 0, len(resp.Hits.Hits)),
+	}
+	for _, h := range resp.Hits.Hits {
+		converted, err := toHit(h)
+		if err != nil {
+			return storage.SearchResult{}, err
+		}
+		result.Hits = append(result.Hits, converted)
+	}
+
+	if len(q.Aggs) > 0 {
+		result.Aggregations, err = storage.DecodeAggregations(q.Aggs, resp.Aggregations)
+		if err != nil {
+			return storage.SearchResult{}, err
+		}
+	}
+	return result, nil
+}
+
+func (e ElasticClient) IndexExists(ctx context.Context, index string) (bool, error) {
+	err := e.do(ctx, "index_exists", index, esapi.IndicesExistsRequest{Index: []string{index}}, nil)
+	if err == storage.ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (e ElasticClient) EnsureIndex(ctx context.Context, index string, mapping storage.Mapping) error {
+	exists, err := e.IndexExists(ctx, index)
+	if err != nil || exists {
+		return err
+	}
+
+	properties := map[string]interface{}{
+		"@timestamp": map[string]interface{}{"type": storage.FieldDate},
+		idField:      map[string]interface{}{"type": storage.FieldKeyword},
+	}
+	for field, fieldType := range mapping {
+		properties[field] = map[string]interface{}{"type": fieldType}
+	}
+
+	body, err := encode(map[string]interface{}{
+		"mappings": map[string]interface{}{"properties": properties},
+	})
+	if err != nil {
+		return err
+	}
+
+	return e.do(ctx, "create_index", index, esapi.IndicesCreateRequest{Index: index, Body: body}, nil)
+}
+
+func (e ElasticClient) Bulk(ctx context.Context, index string, documents []storage.Document) ([]storage.BulkItem, error) {
+	if len(documents) == 0 {
+		return nil, nil
+	}
+
+	body := bytes.Buffer{}
+	encoder := jsoniter.NewEncoder(&body)
+	for _, document := range documents {
+		id := document.ID
+		if id == "" {
+			id = uuid.NewString()
+		}
+
+		doc, err := toDocument(id, document.Source)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := encoder.Encode(map[string]interface{}{"index": map[string]string{"_id": id}}); err != nil {
+			return nil, err
+		}
+		if err := encoder.Encode(doc); err != nil {
+			return nil, err
+		}
+	}
+
+	resp := bulkResponse{}
+	err := e.do(ctx, "bulk", index, esapi.BulkRequest{Index: index, Body: &body}, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resp.Items) != len(documents) {
+		return nil, fmt.Errorf("elasticsearch: bulk answered %d items for %d documents", len(resp.Items), len(documents))
+	}
+
+	items := make([]storage.BulkItem, len(documents))
+	for i, item := range resp.Items {
+		for _, outcome := range item {
+			if outcome.Error != nil || outcome.Status >= 300 {
+				items[i] = storage.BulkItem{Err: fmt.Errorf("elasticsearch: status %d: %v", outcome.Status, outcome.Error)}
+			} else {
+				items[i] = storage.BulkItem{ID: outcome.Id, Result: outcome.Result}
+			}
+		}
+	}
+	return items, nil
+}
+
+func esAggs(aggs map[string]storage.Aggregation) map[string]interface{} {
+	translated := make(map[string]interface{}, len(aggs))
+	for name, agg := range aggs {
+		params := map[string]interface{}{"field": agg.Field}
+
+		switch agg.Type {
+		case storage.AggTerms:
+			if agg.Size > 0 {
+				params["size"] = agg.Size
+			}
+		case storage.AggPercentiles:
+			params["percents"] = agg.Percents
+		case storage.AggRange:
+			ranges := make([]map[string]interface{}, 0, len(agg.Ranges))
+			for _, r := range agg.Ranges {
+				bounds := map[string]interface{}{}
+				if r.From != nil {
+					bounds["from"] = *r.From
+				}
+				if r.To != nil {
+					bounds["to"] = *r.To
+				}
+				ranges = append(ranges, bounds)
+			}
+			params["ranges"] = ranges
+		case storage.AggDateHistogram:
+			params["calendar_interval"] = agg.Interval
+			if agg.TimeZone != "" {
+				params["time_zone"] = agg.TimeZone
+			}
+		}
+
+		body := map[string]interface{}{string(agg.Type): params}
+		if len(agg.Aggs) > 0 {
+			body["aggs"] = esAggs(agg.Aggs)
+		}
+		translated[name] = body
+	}
+	return translated
+}
+
+func esFilter(filter *storage.Filter) map[string]interface{} {
+	var clauses []map[string]interface{}
+	if filter != nil {
+		for i := range filter.And {
+			clauses = append(clauses, esFilter(&filter.And[i]))
+		}
+
+		if len(filter.Or) > 0 {
+			should := make([]map[string]interface{}, 0, len(filter.Or))
+			for i := range filter.Or {
+				should = append(should, esFilter(&filter.Or[i]))
+			}
+			clauses = append(clauses, map[string]interface{}{
+				"bool": map[string]interface{}{"should": should, "minimum_should_match": 1},
+			})
+		}
+
+		if filter.Term != nil {
+			clauses = append(clauses, map[string]interface{}{
+				"term": map[string]interface{}{filter.Term.Field: filter.Term.Value},
+			})
+		}
+
+		if filter.Prefix != nil {
+			clauses = append(clauses, map[string]interface{}{
+				"prefix": map[string]interface{}{filter.Prefix.Field: filter.Prefix.Value},
+			})
+		}
+
+		if filter.Wildcard != nil {
+			clauses = append(clauses, map[string]interface{}{
+				"wildcard": map[string]interface{}{filter.Wildcard.Field: filter.Wildcard.Value},
+			})
+		}
+
+		if filter.Range != nil {
+			bounds := map[string]interface{}{}
+			for name, bound := range map[string]interface{}{
+				"gt":  filter.Range.Gt,
+				"gte": filter.Range.Gte,
+				"lt":  filter.Range.Lt,
+				"lte": filter.Range.Lte,
+			} {
+				if bound != nil {
+					bounds[name] = bound
+				}
+			}
+			clauses = append(clauses, map[string]interface{}{
+				"range": map[string]interface{}{filter.Range.Field: bounds},
+			})
+		}
+	}
+
+	switch len(clauses) {
+	case 0:
+		return map[string]interface{}{"match_all": map[string]interface{}{}}
+	case 1:
+		return clauses[0]
+	}
+	return map[string]interface{}{"bool": map[string]interface{}{"filter": clauses}}
+}
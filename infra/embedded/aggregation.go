@@ -0,0 +1,240 @@
+package embedded
+
+import (
+	"fmt"
+	"math"
+	"sofa-logs-servers/infra/storage"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// defaultTermsSize is the number of buckets a terms aggregation keeps when it sets no size.
+const defaultTermsSize = 10
+
+func (idx *index) aggregate(docs []*document, aggs map[string]storage.Aggregation) (map[string]storage.AggregationResult, error) {
+	results := make(map[string]storage.AggregationResult, len(aggs))
+	for name, agg := range aggs {
+		var result storage.AggregationResult
+		var err error
+
+		switch agg.Type {
+		case storage.AggTerms:
+			result.Buckets, err = idx.terms(docs, agg)
+		case storage.AggRange:
+			result.Buckets, err = idx.ranges(docs, agg)
+		case storage.AggDateHistogram:
+			result.Buckets, err = idx.dateHistogram(docs, agg)
+		case storage.AggCardinality:
+			distinct := map[string]bool{}
+			for _, doc := range docs {
+				if value := doc.fields[agg.Field]; value != nil {
+					distinct[fmt.Sprint(value)] = true
+				}
+			}
+			count := float64(len(distinct))
+			result.Value = &count
+		case storage.AggAvg, storage.AggSum, storage.AggMin, storage.AggMax:
+			result.Value = metric(agg.Type, idx.numbers(docs, agg.Field))
+		case storage.AggPercentiles:
+			result.Percentiles = storage.Percentiles(idx.numbers(docs, agg.Field), agg.Percents)
+		default:
+			err = fmt.Errorf("embedded: %s aggregation: %w", agg.Type, storage.ErrUnsupported)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+		results[name] = result
+	}
+	return results, nil
+}
+
+func (idx *index) terms(docs []*document, agg storage.Aggregation) ([]storage.Bucket, error) {
+	groups := map[string][]*document{}
+	keys := map[string]interface{}{}
+	for _, doc := range docs {
+		value := doc.fields[agg.Field]
+		if value == nil {
+			continue
+		}
+		key := fmt.Sprint(value)
+		groups[key] = append(groups[key], doc)
+		keys[key] = value
+	}
+
+	order := make([]string, 0, len(groups))
+	for key := range groups {
+		order = append(order, key)
+	}
+	sort.Slice(order, func(i, j int) bool {
+		if len(groups[order[i]]) != len(groups[order[j]]) {
+			return len(groups[order[i]]) > len(groups[order[j]])
+		}
+		return order[i] < order[j]
+	})
+
+	size := agg.Size
+	if size <= 0 {
+		size = defaultTermsSize
+	}
+	if size < len(order) {
+		order = order[:size]
+	}
+
+	buckets := make([]storage.Bucket, 0, len(order))
+	for _, key := range order {
+		bucket, err := idx.bucket(keys[key], groups[key], agg.Aggs)
+		if err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, bucket)
+	}
+	return buckets, nil
+}
+
+func (idx *index) ranges(docs []*document, agg storage.Aggregation) ([]storage.Bucket, error) {
+	buckets := make([]storage.Bucket, 0, len(agg.Ranges))
+	for _, r := range agg.Ranges {
+		var matched []*document
+		for _, doc := range docs {
+			value, ok := idx.number(doc, agg.Field)
+			if !ok || (r.From != nil && value < *r.From) || (r.To != nil && value >= *r.To) {
+				continue
+			}
+			matched = append(matched, doc)
+		}
+
+		bucket, err := idx.bucket(rangeKey(r), matched, agg.Aggs)
+		if err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, bucket)
+	}
+	return buckets, nil
+}
+
+// dateHistogram returns a bucket for every interval between the first and the last matching
+// document, empty ones included, as Elasticsearch does.
+func (idx *index) dateHistogram(docs []*document, agg storage.Aggregation) ([]storage.Bucket, error) {
+	location := time.UTC
+	if agg.TimeZone != "" {
+		var err error
+		if location, err = time.LoadLocation(agg.TimeZone); err != nil {
+			return nil, fmt.Errorf("embedded: date histogram time zone: %w", err)
+		}
+	}
+
+	groups := map[int64][]*document{}
+	var first, last time.Time
+	for _, doc := range docs {
+		value, ok := doc.fields[agg.Field].(string)
+		if !ok {
+			continue
+		}
+		t, err := toTime(value)
+		if err != nil {
+			continue
+		}
+
+		start := agg.Interval.Truncate(t.In(location))
+		groups[start.UnixMilli()] = append(groups[start.UnixMilli()], doc)
+		if first.IsZero() || start.Before(first) {
+			first = start
+		}
+		if last.IsZero() || start.After(last) {
+			last = start
+		}
+	}
+
+	var buckets []storage.Bucket
+	if first.IsZero() {
+		return buckets, nil
+	}
+
+	for start := first; !start.After(last); start = agg.Interval.Next(start) {
+		bucket, err := idx.bucket(float64(start.UnixMilli()), groups[start.UnixMilli()], agg.Aggs)
+		if err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, bucket)
+	}
+	return buckets, nil
+}
+
+func (idx *index) bucket(key interface{}, docs []*document, aggs map[string]storage.Aggregation) (storage.Bucket, error) {
+	bucket := storage.Bucket{Key: key, Count: len(docs)}
+	if len(aggs) == 0 {
+		return bucket, nil
+	}
+
+	var err error
+	bucket.Aggs, err = idx.aggregate(docs, aggs)
+	return bucket, err
+}
+
+// rangeKey names a range bucket the way Elasticsearch does, like "*-10000.0".
+func rangeKey(r storage.AggregationRange) string {
+	from, to := "*", "*"
+	if r.From != nil {
+		from = strconv.FormatFloat(*r.From, 'f', 1, 64)
+	}
+	if r.To != nil {
+		to = strconv.FormatFloat(*r.To, 'f', 1, 64)
+	}
+	return from + "-" + to
+}
+
+// number reads a numeric field of doc. Dates count as milliseconds since the epoch, as they do in
+// Elasticsearch aggregations.
+func (idx *index) number(doc *document, field string) (float64, bool) {
+	switch value := doc.fields[field].(type) {
+	case float64:
+		return value, true
+	case string:
+		if idx.mapping[field] == storage.FieldDate {
+			if t, err := toTime(value); err == nil {
+				return float64(t.UnixMilli()), true
+			}
+		}
+	}
+	return 0, false
+}
+
+func (idx *index) numbers(docs []*document, field string) []float64 {
+	values := make([]float64, 0, len(docs))
+	for _, doc := range docs {
+		if value, ok := idx.number(doc, field); ok {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+func metric(aggType storage.AggregationType, values []float64) *float64 {
+	if len(values) == 0 {
+		if aggType == storage.AggSum {
+			zero := 0.0
+			return &zero
+		}
+		return nil
+	}
+
+	sum, min, max := 0.0, values[0], values[0]
+	for _, value := range values {
+		sum += value
+		min = math.Min(min, value)
+		max = math.Max(max, value)
+	}
+
+	result := sum
+	switch aggType {
+	case storage.AggAvg:
+		result = sum / float64(len(values))
+	case storage.AggMin:
+		result = min
+	case storage.AggMax:
+		result = max
+	}
+	return &result
+}
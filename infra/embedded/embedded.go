@@ -0,0 +1,617 @@
+package embedded
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sofa-logs-servers/infra/storage"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DefaultSize is the number of hits returned when a query does not set one, like Elasticsearch.
+const DefaultSize = 10
+
+var _ storage.Storage = (*Store)(nil)
+
+// Store keeps every index in memory. When it is opened with a path, each change is also appended
+// to that file and replayed on the next Open, so the data survives restarts.
+type Store struct {
+	mu      sync.RWMutex
+	indices map[string]*index
+	seq     uint64
+	file    *os.File
+}
+
+type index struct {
+	mapping storage.Mapping
+	docs    map[string]*document
+}
+
+type document struct {
+	id        string
+	seq       uint64
+	timestamp time.Time
+	source    json.RawMessage
+	fields    map[string]interface{}
+}
+
+// record is one line of the append-only file.
+type record struct {
+	Op        string          `json:"op"`
+	Index     string          `json:"index"`
+	ID        string          `json:"id,omitempty"`
+	Timestamp time.Time       `json:"timestamp,omitempty"`
+	Mapping   storage.Mapping `json:"mapping,omitempty"`
+	Source    json.RawMessage `json:"source,omitempty"`
+}
+
+const (
+	opCreateIndex = "create_index"
+	opPut         = "put"
+	opDelete      = "delete"
+)
+
+// Open returns a store persisted to path, or a purely in-memory one when path is empty.
+func Open(path string) (*Store, error) {
+	s := &Store{indices: map[string]*index{}}
+	if path == "" {
+		return s, nil
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := s.replay(file)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	if records > s.live() {
+		// The file is replaced rather than rewritten in place, so a crash or a full disk while
+		// compacting leaves the old file whole.
+		if err := file.Close(); err != nil {
+			return nil, err
+		}
+		if err := storage.ReplaceFile(path, s.compact); err != nil {
+			return nil, err
+		}
+		if file, err = os.OpenFile(path, os.O_RDWR, 0o644); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := file.Seek(0, io.SeekEnd); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	s.file = file
+	return s, nil
+}
+
+// Close releases the append-only file.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// replay applies every record of file and returns how many there were. A torn or unreadable last
+// line, left by a crash in the middle of a write, is cut off.
+func (s *Store) replay(file *os.File) (int, error) {
+	reader := bufio.NewReader(file)
+	records := 0
+	var offset int64
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				return records, file.Truncate(offset)
+			}
+			return records, nil
+		}
+		if err != nil {
+			return records, err
+		}
+
+		rec := record{}
+		if err := json.Unmarshal(line, &rec); err != nil {
+			if _, peekErr := reader.Peek(1); peekErr == io.EOF {
+				return records, file.Truncate(offset)
+			}
+			return records, fmt.Errorf("embedded: corrupt record at offset %d: %w", offset, err)
+		}
+
+		if err := s.apply(rec); err != nil {
+			return records, err
+		}
+		offset += int64(len(line))
+		records++
+	}
+}
+
+// live counts the records needed to rebuild the current state.
+func (s *Store) live() int {
+	count := 0
+	for _, idx := range s.indices {
+		count += 1 + len(idx.docs)
+	}
+	return count
+}
+
+// compact writes the records that rebuild the current state to w.
+func (s *Store) compact(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	for name, idx := range s.indices {
+		if err := encoder.Encode(record{Op: opCreateIndex, Index: name, Mapping: idx.mapping}); err != nil {
+			return err
+		}
+
+		docs := make([]*document, 0, len(idx.docs))
+		for _, doc := range idx.docs {
+			docs = append(docs, doc)
+		}
+		sort.Slice(docs, func(i, j int) bool { return docs[i].seq < docs[j].seq })
+
+		for _, doc := range docs {
+			rec := record{Op: opPut, Index: name, ID: doc.id, Timestamp: doc.timestamp, Source: doc.source}
+			if err := encoder.Encode(rec); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// commit applies rec in memory and appends it to the file. The caller holds the write lock.
+func (s *Store) commit(rec record) error {
+	if s.file != nil {
+		line, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		offset, err := s.file.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		if _, err := s.file.Write(append(line, '\n')); err != nil {
+			return s.truncate(offset, err)
+		}
+	}
+	return s.apply(rec)
+}
+
+// truncate cuts the file back to offset after a failed write, so that a partly written line is not
+// glued to the next record, and returns err.
+func (s *Store) truncate(offset int64, err error) error {
+	if truncErr := s.file.Truncate(offset); truncErr != nil {
+		return fmt.Errorf("%w (truncating the torn record failed: %v)", err, truncErr)
+	}
+	if _, seekErr := s.file.Seek(offset, io.SeekStart); seekErr != nil {
+		return fmt.Errorf("%w (seeking past the torn record failed: %v)", err, seekErr)
+	}
+	return err
+}
+
+func (s *Store) apply(rec record) error {
+	switch rec.Op {
+	case opCreateIndex:
+		if _, ok := s.indices[rec.Index]; !ok {
+			s.indices[rec.Index] = &index{mapping: rec.Mapping, docs: map[string]*document{}}
+		}
+	case opPut:
+		fields := map[string]interface{}{}
+		if err := json.Unmarshal(rec.Source, &fields); err != nil {
+			return err
+		}
+
+		idx := s.index(rec.Index)
+		s.seq++
+		seq := s.seq
+		if existing, ok := idx.docs[rec.ID]; ok {
+			seq = existing.seq
+		}
+		idx.docs[rec.ID] = &document{
+			id:        rec.ID,
+			seq:       seq,
+			timestamp: rec.Timestamp,
+			source:    rec.Source,
+			fields:    fields,
+		}
+	case opDelete:
+		delete(s.index(rec.Index).docs, rec.ID)
+	default:
+		return fmt.Errorf("embedded: unknown record op %q", rec.Op)
+	}
+	return nil
+}
+
+// index returns the named index, creating it without a mapping the way Zinc and Elasticsearch do
+// when a document is written to an unknown index.
+func (s *Store) index(name string) *index {
+	idx, ok := s.indices[name]
+	if !ok {
+		idx = &index{docs: map[string]*document{}}
+		s.indices[name] = idx
+	}
+	return idx
+}
+
+func (s *Store) put(index, id string, document interface{}) error {
+	source, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+
+	return s.commit(record{Op: opPut, Index: index, ID: id, Timestamp: time.Now(), Source: source})
+}
+
+func (d *document) hit(index string) storage.Hit {
+	return storage.Hit{
+		Index:     index,
+		ID:        d.id,
+		Score:     1,
+		Timestamp: d.timestamp,
+		Source:    d.source,
+	}
+}
+
+func (s *Store) Index(_ context.Context, index string, document interface{}) (storage.IndexResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := uuid.NewString()
+	if err := s.put(index, id, document); err != nil {
+		return storage.IndexResult{}, err
+	}
+	return storage.IndexResult{ID: id, Result: storage.ResultCreated}, nil
+}
+
+func (s *Store) Bulk(_ context.Context, index string, documents []storage.Document) ([]storage.BulkItem, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	items := make([]storage.BulkItem, len(documents))
+	for i, document := range documents {
+		id := document.ID
+		if id == "" {
+			id = uuid.NewString()
+		}
+
+		if err := s.put(index, id, document.Source); err != nil {
+			items[i] = storage.BulkItem{Err: err}
+			continue
+		}
+		items[i] = storage.BulkItem{ID: id, Result: storage.ResultCreated}
+	}
+	return items, nil
+}
+
+func (s *Store) Update(_ context.Context, index, id string, document interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.index(index).docs[id]; !ok {
+		return storage.ErrNotFound
+	}
+	return s.put(index, id, document)
+}
+
+func (s *Store) Delete(_ context.Context, index, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.index(index).docs[id]; !ok {
+		return storage.ErrNotFound
+	}
+	return s.commit(record{Op: opDelete, Index: index, ID: id})
+}
+
+func (s *Store) Get(_ context.Context, index, id string) (storage.Hit, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	idx, ok := s.indices[index]
+	if !ok {
+		return storage.Hit{}, storage.ErrNotFound
+	}
+
+	doc, ok := idx.docs[id]
+	if !ok {
+		return storage.Hit{}, storage.ErrNotFound
+	}
+	return doc.hit(index), nil
+}
+
+func (s *Store) Search(_ context.Context, index string, q storage.Query) (storage.SearchResult, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	idx, ok := s.indices[index]
+	if !ok {
+		return storage.SearchResult{}, storage.ErrNotFound
+	}
+
+	var after []interface{}
+	if len(q.After) > 0 {
+		if len(q.After) != len(q.Sort)+1 {
+			return storage.SearchResult{}, fmt.Errorf("embedded: search_after needs %d values", len(q.Sort)+1)
+		}
+		after = normalize(q.After).([]interface{})
+	}
+
+	docs := make([]*document, 0, len(idx.docs))
+	for _, doc := range idx.docs {
+		if idx.matches(doc, q.Filter) {
+			docs = append(docs, doc)
+		}
+	}
+	total := len(docs)
+
+	var aggregations map[string]storage.AggregationResult
+	if len(q.Aggs) > 0 {
+		var err error
+		if aggregations, err = idx.aggregate(docs, q.Aggs); err != nil {
+			return storage.SearchResult{}, err
+		}
+	}
+
+	keys := make(map[*document][]interface{}, len(docs))
+	for _, doc := range docs {
+		keys[doc] = doc.sortValues(q.Sort)
+	}
+	sort.Slice(docs, func(i, j int) bool {
+		return idx.compareKeys(keys[docs[i]], keys[docs[j]], q.Sort) < 0
+	})
+
+	if after != nil {
+		start := sort.Search(len(docs), func(i int) bool {
+			return idx.compareKeys(keys[docs[i]], after, q.Sort) > 0
+		})
+		docs = docs[start:]
+	}
+
+	size := q.Size
+	if size <= 0 && len(q.Aggs) == 0 {
+		size = DefaultSize
+	}
+	if size < 0 {
+		size = 0
+	}
+	if size > len(docs) {
+		size = len(docs)
+	}
+
+	result := storage.SearchResult{Total: total, Hits: make([]storage.Hit, 0, size), Aggregations: aggregations}
+	for _, doc := range docs[:size] {
+		hit := doc.hit(index)
+		hit.Sort = keys[doc]
+		result.Hits = append(result.Hits, hit)
+	}
+	return result, nil
+}
+
+func (s *Store) IndexExists(_ context.Context, index string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.indices[index]
+	return ok, nil
+}
+
+func (s *Store) EnsureIndex(_ context.Context, index string, mapping storage.Mapping) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.indices[index]; ok {
+		return nil
+	}
+	return s.commit(record{Op: opCreateIndex, Index: index, Mapping: mapping})
+}
+
+// compare orders two decoded JSON values. Missing values sort after present ones and date fields
+// are compared as instants rather than as strings.
+func compare(a, b interface{}, fieldType storage.FieldType) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return 1
+	case b == nil:
+		return -1
+	}
+
+	if fieldType == storage.FieldDate {
+		ta, errA := toTime(a)
+		tb, errB := toTime(b)
+		if errA == nil && errB == nil {
+			switch {
+			case ta.Before(tb):
+				return -1
+			case ta.After(tb):
+				return 1
+			}
+			return 0
+		}
+	}
+
+	switch av := a.(type) {
+	case float64:
+		if bv, ok := b.(float64); ok {
+			switch {
+			case av < bv:
+				return -1
+			case av > bv:
+				return 1
+			}
+			return 0
+		}
+	case string:
+		if bv, ok := b.(string); ok {
+			return strings.Compare(av, bv)
+		}
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+// sortValues returns the values doc is ordered by, ending with its insertion sequence as a
+// tie-breaker.
+func (d *document) sortValues(sort []storage.SortField) []interface{} {
+	values := make([]interface{}, 0, len(sort)+1)
+	for _, field := range sort {
+		values = append(values, d.fields[field.Field])
+	}
+	return append(values, float64(d.seq))
+}
+
+func (idx *index) compareKeys(a, b []interface{}, sort []storage.SortField) int {
+	for i, field := range sort {
+		c := compare(a[i], b[i], idx.mapping[field.Field])
+		if field.Desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return compare(a[len(sort)], b[len(sort)], storage.FieldLong)
+}
+
+// matches reports whether doc satisfies filter.
+func (idx *index) matches(doc *document, filter *storage.Filter) bool {
+	if filter == nil {
+		return true
+	}
+
+	for i := range filter.And {
+		if !idx.matches(doc, &filter.And[i]) {
+			return false
+		}
+	}
+
+	if len(filter.Or) > 0 {
+		matched := false
+		for i := range filter.Or {
+			if idx.matches(doc, &filter.Or[i]) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	if t := filter.Term; t != nil {
+		value := doc.fields[t.Field]
+		if value == nil || compare(value, normalize(t.Value), idx.mapping[t.Field]) != 0 {
+			return false
+		}
+	}
+
+	if p := filter.Prefix; p != nil {
+		value, ok := doc.fields[p.Field].(string)
+		if !ok || !strings.HasPrefix(value, p.Value) {
+			return false
+		}
+	}
+
+	if p := filter.Wildcard; p != nil {
+		value, ok := doc.fields[p.Field].(string)
+		if !ok || !wildcardMatch(p.Value, value) {
+			return false
+		}
+	}
+
+	if r := filter.Range; r != nil {
+		value := doc.fields[r.Field]
+		if value == nil {
+			return false
+		}
+
+		fieldType := idx.mapping[r.Field]
+		if r.Gt != nil && compare(value, normalize(r.Gt), fieldType) <= 0 {
+			return false
+		}
+		if r.Gte != nil && compare(value, normalize(r.Gte), fieldType) < 0 {
+			return false
+		}
+		if r.Lt != nil && compare(value, normalize(r.Lt), fieldType) >= 0 {
+			return false
+		}
+		if r.Lte != nil && compare(value, normalize(r.Lte), fieldType) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// wildcardMatch reports whether value matches pattern, where * matches any run of characters and
+// ? a single one.
+func wildcardMatch(pattern, value string) bool {
+	p, v := []rune(pattern), []rune(value)
+	pi, vi := 0, 0
+	star, mark := -1, 0
+
+	for vi < len(v) {
+		switch {
+		case pi < len(p) && (p[pi] == '?' || p[pi] == v[vi]):
+			pi++
+			vi++
+		case pi < len(p) && p[pi] == '*':
+			star, mark = pi, vi
+			pi++
+		case star >= 0:
+			pi = star + 1
+			mark++
+			vi = mark
+		default:
+			return false
+		}
+	}
+
+	for pi < len(p) && p[pi] == '*' {
+		pi++
+	}
+	return pi == len(p)
+}
+
+// normalize brings a Go value to the form it has once decoded from a stored document, so it can
+// be compared with document fields.
+func normalize(value interface{}) interface{} {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return value
+	}
+
+	var decoded interface{}
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		return value
+	}
+	return decoded
+}
+
+func toTime(value interface{}) (time.Time, error) {
+	str, ok := value.(string)
+	if !ok {
+		return time.Time{}, fmt.Errorf("embedded: %v is not a date", value)
+	}
+	return time.Parse(time.RFC3339Nano, str)
+}
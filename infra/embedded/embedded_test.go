@@ -0,0 +1,191 @@
+package embedded
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"sofa-logs-servers/infra/storage"
+	"testing"
+)
+
+var mapping = storage.Mapping{"page": storage.FieldKeyword}
+
+func open(t *testing.T, path string) *Store {
+	t.Helper()
+	store, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	return store
+}
+
+func page(t *testing.T, store *Store, id string) string {
+	t.Helper()
+	hit, err := store.Get(context.Background(), "logs", id)
+	if err != nil {
+		t.Fatalf("get %s: %v", id, err)
+	}
+	return string(hit.Source)
+}
+
+func lines(t *testing.T, path string) int {
+	t.Helper()
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.Count(raw, []byte("\n"))
+}
+
+func TestReopen(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "store.jsonl")
+
+	store := open(t, path)
+	if err := store.EnsureIndex(ctx, "logs", mapping); err != nil {
+		t.Fatal(err)
+	}
+	kept, err := store.Index(ctx, "logs", map[string]string{"page": "/a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleted, err := store.Index(ctx, "logs", map[string]string{"page": "/b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Update(ctx, "logs", kept.ID, map[string]string{"page": "/c"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(ctx, "logs", deleted.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store = open(t, path)
+	if got := page(t, store, kept.ID); got != `{"page":"/c"}` {
+		t.Errorf("reopened document = %s", got)
+	}
+	if _, err := store.Get(ctx, "logs", deleted.ID); err != storage.ErrNotFound {
+		t.Errorf("deleted document: err = %v", err)
+	}
+	if exists, _ := store.IndexExists(ctx, "logs"); !exists {
+		t.Error("index lost")
+	}
+}
+
+// Opening compacts the file down to one record per index and live document, and the compacted
+// file opens to the same state.
+func TestCompaction(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "store.jsonl")
+
+	store := open(t, path)
+	if err := store.EnsureIndex(ctx, "logs", mapping); err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, p := range []string{"/a", "/b", "/c"} {
+		res, err := store.Index(ctx, "logs", map[string]string{"page": p})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, res.ID)
+	}
+	if err := store.Update(ctx, "logs", ids[0], map[string]string{"page": "/d"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(ctx, "logs", ids[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := lines(t, path); n != 6 {
+		t.Fatalf("%d records before compaction, want 6", n)
+	}
+
+	store = open(t, path)
+	if n := lines(t, path); n != 3 {
+		t.Errorf("%d records after compaction, want 3", n)
+	}
+	if _, err := store.Index(ctx, "logs", map[string]string{"page": "/e"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store = open(t, path)
+	res, err := store.Search(ctx, "logs", storage.Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Total != 3 {
+		t.Errorf("total = %d, want 3", res.Total)
+	}
+	if got := page(t, store, ids[0]); got != `{"page":"/d"}` {
+		t.Errorf("updated document = %s", got)
+	}
+}
+
+func TestReplayCutsTornTail(t *testing.T) {
+	tests := map[string]string{
+		"unterminated": `{"op":"put","index":"logs","id":"x","sou`,
+		"unreadable":   "{\"op\":\"put\",\"index\":\"logs\",\"id\":\"x\",\"sou\n",
+	}
+
+	for name, tail := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			path := filepath.Join(t.TempDir(), "store.jsonl")
+
+			store := open(t, path)
+			if err := store.EnsureIndex(ctx, "logs", mapping); err != nil {
+				t.Fatal(err)
+			}
+			kept, err := store.Index(ctx, "logs", map[string]string{"page": "/a"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := file.WriteString(tail); err != nil {
+				t.Fatal(err)
+			}
+			if err := file.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			store = open(t, path)
+			if got := page(t, store, kept.ID); got != `{"page":"/a"}` {
+				t.Errorf("document = %s", got)
+			}
+			if n := lines(t, path); n != 2 {
+				t.Errorf("%d records left, want 2", n)
+			}
+		})
+	}
+}
+
+// A corrupt record followed by good ones is not a torn write and must not be dropped silently.
+func TestReplayRejectsCorruptRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.jsonl")
+	content := "{broken\n" + `{"op":"create_index","index":"logs"}` + "\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Open(path); err == nil {
+		t.Fatal("corrupt file opened")
+	}
+}
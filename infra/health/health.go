@@ -0,0 +1,129 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sofa-logs-servers/infra/storage"
+	"sync"
+	"time"
+)
+
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// Probe checks one dependency, failing with an error that says what is wrong.
+type Probe func(ctx context.Context) error
+
+type CheckResult struct {
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+	LatencyMs int64  `json:"latency_ms"`
+}
+
+type Report struct {
+	Status    string                 `json:"status"`
+	CheckedAt time.Time              `json:"checked_at"`
+	Checks    map[string]CheckResult `json:"checks"`
+}
+
+// Checker runs its probes at most once per TTL and answers from the last report in between, so
+// frequent readiness checks do not load the backend.
+type Checker struct {
+	probes  map[string]Probe
+	ttl     time.Duration
+	timeout time.Duration
+
+	mu     sync.Mutex
+	report *Report
+	// running is closed once the probes in progress, if any, are done.
+	running chan struct{}
+}
+
+// NewChecker runs each probe with timeout and caches reports for ttl.
+func NewChecker(ttl, timeout time.Duration, probes map[string]Probe) *Checker {
+	return &Checker{probes: probes, ttl: ttl, timeout: timeout}
+}
+
+// Check returns the cached report, or probes every dependency when it is older than the TTL.
+// Concurrent checks share one round of probes, which runs without the caller's context so a
+// client hanging up is not reported as a failing dependency. Check stops waiting when ctx is done.
+func (c *Checker) Check(ctx context.Context) Report {
+	c.mu.Lock()
+	if c.report != nil && time.Since(c.report.CheckedAt) < c.ttl {
+		report := *c.report
+		c.mu.Unlock()
+		return report
+	}
+	if c.running == nil {
+		c.running = make(chan struct{})
+		go c.probe(c.running)
+	}
+	running := c.running
+	c.mu.Unlock()
+
+	select {
+	case <-running:
+	case <-ctx.Done():
+		return Report{Status: StatusFail, CheckedAt: time.Now(), Checks: map[string]CheckResult{}}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return *c.report
+}
+
+// probe runs every probe, caches the report and closes done.
+func (c *Checker) probe(done chan struct{}) {
+	report := Report{Status: StatusOK, CheckedAt: time.Now(), Checks: map[string]CheckResult{}}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for name, probe := range c.probes {
+		wg.Add(1)
+		go func(name string, probe Probe) {
+			defer wg.Done()
+
+			probeCtx, cancel := context.WithTimeout(context.Background(), c.timeout)
+			defer cancel()
+
+			start := time.Now()
+			err := probe(probeCtx)
+			result := CheckResult{Status: StatusOK, LatencyMs: time.Since(start).Milliseconds()}
+			if err != nil {
+				result.Status = StatusFail
+				result.Error = err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[name] = result
+			if err != nil {
+				report.Status = StatusFail
+			}
+		}(name, probe)
+	}
+	wg.Wait()
+
+	c.mu.Lock()
+	c.report = &report
+	c.running = nil
+	c.mu.Unlock()
+	close(done)
+}
+
+// IndexProbe checks that store answers and has index.
+func IndexProbe(store storage.Storage, index string) Probe {
+	return func(ctx context.Context) error {
+		exists, err := store.IndexExists(ctx, index)
+		if err != nil {
+			return fmt.Errorf("backend unreachable: %w", err)
+		}
+		if !exists {
+			return errors.New("index " + index + " does not exist")
+		}
+		return nil
+	}
+}
@@ -0,0 +1,430 @@
+package ingest
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sofa-logs-servers/infra/storage"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// maxAttempts bounds how often a document the backend rejects is retried before it is dropped.
+	maxAttempts = 5
+	minBackoff  = 500 * time.Millisecond
+	maxBackoff  = 30 * time.Second
+	// flushTimeout bounds a single bulk call to the backend.
+	flushTimeout = 30 * time.Second
+)
+
+type Config struct {
+	// WALPath is the write-ahead log pending documents are kept in until the backend has them.
+	WALPath       string
+	BatchSize     int
+	FlushInterval time.Duration
+	// MaxPending is the number of unflushed documents above which new ones are refused.
+	MaxPending int
+}
+
+var _ storage.Storage = (*Queue)(nil)
+
+// Queue buffers new documents in front of a store. Index and Bulk only append to the write-ahead
+// log and return, a background loop writes the documents to the store in batches and retries
+// with backoff while it is unavailable. Every other call goes straight to the store.
+type Queue struct {
+	storage.Storage
+	config Config
+
+	mu      sync.Mutex
+	wal     *os.File
+	seq     uint64
+	pending []*entry
+
+	wake    chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+type entry struct {
+	seq      uint64
+	index    string
+	id       string
+	source   json.RawMessage
+	attempts int
+}
+
+// record is one line of the write-ahead log. Ack records mark a document as written.
+type record struct {
+	Seq    uint64          `json:"seq"`
+	Ack    bool            `json:"ack,omitempty"`
+	Index  string          `json:"index,omitempty"`
+	ID     string          `json:"id,omitempty"`
+	Source json.RawMessage `json:"source,omitempty"`
+}
+
+// Open replays the write-ahead log at config.WALPath and starts flushing to store.
+func Open(store storage.Storage, config Config) (*Queue, error) {
+	file, err := os.OpenFile(config.WALPath, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &Queue{
+		Storage: store,
+		config:  config,
+		wal:     file,
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+
+	if err := q.replay(); err != nil {
+		if q.wal != nil {
+			_ = q.wal.Close()
+		}
+		return nil, err
+	}
+	if len(q.pending) > 0 {
+		slog.Info("replaying the ingestion write-ahead log", "documents", len(q.pending))
+	}
+
+	go q.run()
+	return q, nil
+}
+
+// Pending returns the number of documents waiting to be written to the store.
+func (q *Queue) Pending() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.pending)
+}
+
+// Close stops the background loop and flushes what is pending until ctx is done.
+// Documents that could not be written stay in the write-ahead log for the next start.
+func (q *Queue) Close(ctx context.Context) error {
+	close(q.done)
+	<-q.stopped
+
+	for q.Pending() > 0 {
+		if err := q.flush(ctx); err != nil {
+			select {
+			case <-ctx.Done():
+				return q.closeWAL(fmt.Errorf("ingest: %d documents left in the write-ahead log: %w", q.Pending(), err))
+			case <-time.After(minBackoff):
+			}
+		}
+	}
+	return q.closeWAL(nil)
+}
+
+func (q *Queue) closeWAL(err error) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if closeErr := q.wal.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (q *Queue) Index(_ context.Context, index string, document interface{}) (storage.IndexResult, error) {
+	ids, err := q.enqueue(index, []storage.Document{{Source: document}})
+	if err != nil {
+		return storage.IndexResult{}, err
+	}
+	return storage.IndexResult{ID: ids[0], Result: storage.ResultQueued}, nil
+}
+
+func (q *Queue) Bulk(_ context.Context, index string, documents []storage.Document) ([]storage.BulkItem, error) {
+	ids, err := q.enqueue(index, documents)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]storage.BulkItem, len(ids))
+	for i, id := range ids {
+		items[i] = storage.BulkItem{ID: id, Result: storage.ResultQueued}
+	}
+	return items, nil
+}
+
+// enqueue makes documents durable in the write-ahead log and hands them to the flush loop.
+func (q *Queue) enqueue(index string, documents []storage.Document) ([]string, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.pending)+len(documents) > q.config.MaxPending {
+		return nil, fmt.Errorf("%w: ingestion queue is full", storage.ErrUnavailable)
+	}
+
+	entries := make([]*entry, 0, len(documents))
+	lines := make([]byte, 0, 512*len(documents))
+	for _, document := range documents {
+		source, err := json.Marshal(document.Source)
+		if err != nil {
+			return nil, err
+		}
+
+		id := document.ID
+		if id == "" {
+			id = uuid.NewString()
+		}
+
+		q.seq++
+		e := &entry{seq: q.seq, index: index, id: id, source: source}
+		line, err := json.Marshal(record{Seq: e.seq, Index: e.index, ID: e.id, Source: e.source})
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, e)
+		lines = append(append(lines, line...), '\n')
+	}
+
+	if err := q.append(lines); err != nil {
+		return nil, err
+	}
+	q.pending = append(q.pending, entries...)
+
+	if len(q.pending) >= q.config.BatchSize {
+		select {
+		case q.wake <- struct{}{}:
+		default:
+		}
+	}
+
+	ids := make([]string, len(entries))
+	for i, e := range entries {
+		ids[i] = e.id
+	}
+	return ids, nil
+}
+
+// append writes lines to the write-ahead log and syncs it. The caller holds the lock. When that
+// fails the log is cut back to where it ended, so a partly written line is neither replayed nor
+// glued to the next one.
+func (q *Queue) append(lines []byte) error {
+	info, err := q.wal.Stat()
+	if err != nil {
+		return err
+	}
+
+	if _, err = q.wal.Write(lines); err == nil {
+		err = q.wal.Sync()
+	}
+	if err != nil {
+		if truncErr := q.wal.Truncate(info.Size()); truncErr != nil {
+			return fmt.Errorf("%w (truncating the write-ahead log failed: %v)", err, truncErr)
+		}
+	}
+	return err
+}
+
+func (q *Queue) run() {
+	defer close(q.stopped)
+
+	ticker := time.NewTicker(q.config.FlushInterval)
+	defer ticker.Stop()
+
+	backoff := minBackoff
+	for {
+		select {
+		case <-q.done:
+			return
+		case <-ticker.C:
+		case <-q.wake:
+		}
+
+		for q.Pending() > 0 {
+			ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+			err := q.flush(ctx)
+			cancel()
+
+			if err == nil {
+				backoff = minBackoff
+				continue
+			}
+
+			slog.Warn("flushing the ingestion queue failed", "retry_in", backoff, "err", err)
+			select {
+			case <-q.done:
+				return
+			case <-time.After(backoff):
+			}
+			backoff = nextBackoff(backoff)
+		}
+	}
+}
+
+// nextBackoff doubles backoff up to maxBackoff.
+func nextBackoff(backoff time.Duration) time.Duration {
+	backoff *= 2
+	if backoff > maxBackoff {
+		return maxBackoff
+	}
+	return backoff
+}
+
+// flush writes the oldest batch of pending documents to the store.
+func (q *Queue) flush(ctx context.Context) error {
+	q.mu.Lock()
+	batch := q.pending
+	if len(batch) > q.config.BatchSize {
+		batch = batch[:q.config.BatchSize]
+	}
+	batch = append([]*entry(nil), batch...)
+	q.mu.Unlock()
+
+	byIndex := map[string][]*entry{}
+	var indices []string
+	for _, e := range batch {
+		if _, ok := byIndex[e.index]; !ok {
+			indices = append(indices, e.index)
+		}
+		byIndex[e.index] = append(byIndex[e.index], e)
+	}
+
+	written := map[uint64]bool{}
+	var flushErr error
+	for _, index := range indices {
+		entries := byIndex[index]
+		documents := make([]storage.Document, len(entries))
+		for i, e := range entries {
+			documents[i] = storage.Document{ID: e.id, Source: e.source}
+		}
+
+		items, err := q.Storage.Bulk(ctx, index, documents)
+		if err != nil {
+			flushErr = err
+			continue
+		}
+
+		for i, item := range items {
+			e := entries[i]
+			if item.Err == nil {
+				written[e.seq] = true
+				continue
+			}
+
+			e.attempts++
+			if e.attempts >= maxAttempts {
+				slog.Error("dropping queued document", "id", e.id, "index", e.index, "attempts", e.attempts, "err", item.Err)
+				written[e.seq] = true
+			}
+		}
+	}
+
+	if err := q.ack(written); err != nil {
+		return err
+	}
+	return flushErr
+}
+
+// ack removes the written documents from the queue and records that in the write-ahead log,
+// which is emptied once nothing is pending anymore.
+func (q *Queue) ack(written map[uint64]bool) error {
+	if len(written) == 0 {
+		return nil
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	remaining := q.pending[:0]
+	for _, e := range q.pending {
+		if !written[e.seq] {
+			remaining = append(remaining, e)
+		}
+	}
+	q.pending = remaining
+
+	if len(q.pending) == 0 {
+		if err := q.wal.Truncate(0); err != nil {
+			return err
+		}
+		_, err := q.wal.Seek(0, io.SeekStart)
+		return err
+	}
+
+	lines := make([]byte, 0, 32*len(written))
+	for seq := range written {
+		line, err := json.Marshal(record{Seq: seq, Ack: true})
+		if err != nil {
+			return err
+		}
+		lines = append(append(lines, line...), '\n')
+	}
+	return q.append(lines)
+}
+
+// replay loads the documents of the write-ahead log that were never acknowledged and rewrites
+// the log so it only holds those. The log is cut at the first torn or unreadable line, which a
+// crash in the middle of an append leaves behind.
+func (q *Queue) replay() error {
+	reader := bufio.NewReader(q.wal)
+	entries := map[uint64]*entry{}
+	var offset int64
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				slog.Warn("cutting a torn line off the ingestion write-ahead log", "path", q.config.WALPath, "offset", offset)
+			}
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		rec := record{}
+		if err := json.Unmarshal(line, &rec); err != nil {
+			slog.Warn("cutting a corrupt tail off the ingestion write-ahead log", "path", q.config.WALPath, "offset", offset, "err", err)
+			break
+		}
+		offset += int64(len(line))
+
+		if rec.Seq > q.seq {
+			q.seq = rec.Seq
+		}
+		if rec.Ack {
+			delete(entries, rec.Seq)
+		} else {
+			entries[rec.Seq] = &entry{seq: rec.Seq, index: rec.Index, id: rec.ID, source: rec.Source}
+		}
+	}
+
+	for _, e := range entries {
+		q.pending = append(q.pending, e)
+	}
+	sort.Slice(q.pending, func(i, j int) bool { return q.pending[i].seq < q.pending[j].seq })
+
+	// The log is replaced rather than rewritten in place, so a crash now cannot lose what it holds.
+	err := storage.ReplaceFile(q.config.WALPath, func(w io.Writer) error {
+		encoder := json.NewEncoder(w)
+		for _, e := range q.pending {
+			if err := encoder.Encode(record{Seq: e.seq, Index: e.index, ID: e.id, Source: e.source}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := q.wal.Close(); err != nil {
+		return err
+	}
+	q.wal, err = os.OpenFile(q.config.WALPath, os.O_RDWR|os.O_APPEND, 0o644)
+	return err
+}
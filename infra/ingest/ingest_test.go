@@ -0,0 +1,227 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sofa-logs-servers/infra/storage"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// backend records the documents written to it and rejects those whose id it is told to.
+type backend struct {
+	storage.Storage
+
+	mu       sync.Mutex
+	reject   map[string]bool
+	down     bool
+	received []string
+}
+
+func (b *backend) Bulk(_ context.Context, _ string, documents []storage.Document) ([]storage.BulkItem, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.down {
+		return nil, storage.ErrUnavailable
+	}
+	items := make([]storage.BulkItem, len(documents))
+	for i, document := range documents {
+		if b.reject[document.ID] {
+			items[i].Err = errors.New("rejected")
+			continue
+		}
+		items[i].ID = document.ID
+		b.received = append(b.received, document.ID)
+	}
+	return items, nil
+}
+
+func open(t *testing.T, path string, store storage.Storage) *Queue {
+	t.Helper()
+	// The loop never flushes on its own, the tests call flush.
+	q, err := Open(store, Config{WALPath: path, BatchSize: 100, FlushInterval: time.Hour, MaxPending: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+// stop ends the loop of q without flushing what is pending.
+func stop(t *testing.T, q *Queue) {
+	t.Helper()
+	close(q.done)
+	<-q.stopped
+	if err := q.closeWAL(nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func enqueue(t *testing.T, q *Queue, ids ...string) {
+	t.Helper()
+	documents := make([]storage.Document, len(ids))
+	for i, id := range ids {
+		documents[i] = storage.Document{ID: id, Source: map[string]string{"id": id}}
+	}
+	if _, err := q.Bulk(context.Background(), "logs", documents); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func pendingIDs(q *Queue) string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	ids := make([]string, len(q.pending))
+	for i, e := range q.pending {
+		ids[i] = e.id
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestReplay(t *testing.T) {
+	tests := []struct {
+		name    string
+		wal     string
+		pending string
+	}{
+		{"empty", "", ""},
+		{"acked", `{"seq":1,"index":"logs","id":"a","source":{}}` + "\n" + `{"seq":2,"index":"logs","id":"b","source":{}}` + "\n" + `{"seq":1,"ack":true}` + "\n", "b"},
+		{"torn tail", `{"seq":1,"index":"logs","id":"a","source":{}}` + "\n" + `{"seq":2,"index":"lo`, "a"},
+		{"corrupt tail", `{"seq":1,"index":"logs","id":"a","source":{}}` + "\n" + `{"seq":2,"ind` + "\n", "a"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "wal.jsonl")
+			if err := os.WriteFile(path, []byte(test.wal), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			q := open(t, path, &backend{})
+			if got := pendingIDs(q); got != test.pending {
+				t.Errorf("pending = %q, want %q", got, test.pending)
+			}
+			// New documents go after the replayed ones, on a line of their own.
+			enqueue(t, q, "new")
+			stop(t, q)
+
+			q = open(t, path, &backend{})
+			defer stop(t, q)
+			want := strings.TrimPrefix(test.pending+",new", ",")
+			if got := pendingIDs(q); got != want {
+				t.Errorf("pending after reopening = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestAck(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal.jsonl")
+	store := &backend{reject: map[string]bool{"b": true}}
+
+	q := open(t, path, store)
+	enqueue(t, q, "a", "b", "c")
+	if err := q.flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := pendingIDs(q); got != "b" {
+		t.Errorf("pending = %q, want b", got)
+	}
+	stop(t, q)
+
+	// The acknowledgements are in the log, so only the rejected document comes back.
+	q = open(t, path, store)
+	if got := pendingIDs(q); got != "b" {
+		t.Fatalf("pending after reopening = %q, want b", got)
+	}
+
+	store.mu.Lock()
+	store.reject = nil
+	store.mu.Unlock()
+	if err := q.flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	stop(t, q)
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(raw) != 0 {
+		t.Errorf("write-ahead log holds %q once nothing is pending", raw)
+	}
+	if got := strings.Join(store.received, ","); got != "a,c,b" {
+		t.Errorf("backend received %s", got)
+	}
+}
+
+func TestFlushKeepsDocumentsWhileBackendIsDown(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal.jsonl")
+	store := &backend{down: true}
+
+	q := open(t, path, store)
+	defer stop(t, q)
+	enqueue(t, q, "a")
+	for i := 0; i < 2*maxAttempts; i++ {
+		if err := q.flush(context.Background()); !errors.Is(err, storage.ErrUnavailable) {
+			t.Fatalf("flush: err = %v", err)
+		}
+	}
+	if got := pendingIDs(q); got != "a" {
+		t.Errorf("pending = %q, want a", got)
+	}
+}
+
+func TestFlushDropsRejectedDocuments(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal.jsonl")
+	store := &backend{reject: map[string]bool{"a": true}}
+
+	q := open(t, path, store)
+	defer stop(t, q)
+	enqueue(t, q, "a")
+	for i := 1; i <= maxAttempts; i++ {
+		if err := q.flush(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		want := 1
+		if i == maxAttempts {
+			want = 0
+		}
+		if pending := q.Pending(); pending != want {
+			t.Fatalf("%d documents pending after %d attempts, want %d", pending, i, want)
+		}
+	}
+}
+
+func TestEnqueueRefusesPastMaxPending(t *testing.T) {
+	q := open(t, filepath.Join(t.TempDir(), "wal.jsonl"), &backend{})
+	defer stop(t, q)
+
+	documents := make([]storage.Document, q.config.MaxPending+1)
+	if _, err := q.Bulk(context.Background(), "logs", documents); !errors.Is(err, storage.ErrUnavailable) {
+		t.Errorf("err = %v", err)
+	}
+	if q.Pending() != 0 {
+		t.Errorf("%d documents pending", q.Pending())
+	}
+}
+
+func TestNextBackoff(t *testing.T) {
+	var waits []time.Duration
+	for backoff := minBackoff; len(waits) < 10; backoff = nextBackoff(backoff) {
+		waits = append(waits, backoff)
+	}
+
+	want := []time.Duration{minBackoff, time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second,
+		16 * time.Second, maxBackoff, maxBackoff, maxBackoff, maxBackoff}
+	for i := range want {
+		if waits[i] != want[i] {
+			t.Fatalf("waits = %v, want %v", waits, want)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+// Package logging configures the structured logger of the server and carries the logger of a
+// request, tagged with its id, through its context.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+// New returns a logger writing records of level and above to w, as "json" or "text".
+func New(w io.Writer, format, level string) (*slog.Logger, error) {
+	var min slog.Level
+	if err := min.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("logging: unknown level %q", level)
+	}
+
+	options := &slog.HandlerOptions{Level: min}
+	switch format {
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, options)), nil
+	case "text":
+		return slog.New(slog.NewTextHandler(w, options)), nil
+	default:
+		return nil, fmt.Errorf("logging: unknown format %q", format)
+	}
+}
+
+type contextKey struct{}
+
+// WithLogger returns a copy of ctx whose calls log to logger.
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger of ctx, or the default logger when ctx has none.
+func FromContext(ctx context.Context) *slog.Logger {
+	return FromContextOr(ctx, slog.Default())
+}
+
+// FromContextOr returns the logger of ctx, or fallback when ctx has none.
+func FromContextOr(ctx context.Context, fallback *slog.Logger) *slog.Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return fallback
+}
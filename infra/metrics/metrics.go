@@ -0,0 +1,120 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/felixge/httpsnoop"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Registry holds the metrics of the server, next to the Go runtime and process metrics.
+var Registry = prometheus.NewRegistry()
+
+var (
+	requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests by route, method and status.",
+	}, []string{"route", "method", "status"})
+
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Time spent answering HTTP requests by route, method and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	storageDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "storage_operation_duration_seconds",
+		Help:    "Time spent in storage backend calls by operation.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	storageErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "storage_operation_errors_total",
+		Help: "Storage backend calls that failed, by operation.",
+	}, []string{"operation"})
+
+	documentsIndexed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "documents_indexed_total",
+		Help: "Documents written to the storage backend, by index without its tenant prefix.",
+	}, []string{"index"})
+
+	panics = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_handler_panics_total",
+		Help: "Panics recovered from HTTP handlers, by route.",
+	}, []string{"route"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		requests,
+		requestDuration,
+		storageDuration,
+		storageErrors,
+		documentsIndexed,
+		panics,
+	)
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// route labels r with the path template of its mux route so ids in URLs do not create new series.
+func route(r *http.Request) string {
+	if current := mux.CurrentRoute(r); current != nil {
+		if template, err := current.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+	return "unknown"
+}
+
+type matchedKey struct{}
+
+// WithMatchedRoute returns a copy of ctx in which Middleware notes the route the router matched,
+// so Panic can name it when the panic is recovered outside of the router.
+func WithMatchedRoute(ctx context.Context) context.Context {
+	return context.WithValue(ctx, matchedKey{}, new(string))
+}
+
+// Middleware counts and times the requests of a mux router.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := route(r)
+		if matched, ok := r.Context().Value(matchedKey{}).(*string); ok {
+			*matched = route
+		}
+		m := httpsnoop.CaptureMetrics(next, w, r)
+
+		status := strconv.Itoa(m.Code)
+		requests.WithLabelValues(route, r.Method, status).Inc()
+		requestDuration.WithLabelValues(route, r.Method, status).Observe(m.Duration.Seconds())
+	})
+}
+
+// Panic counts a panic recovered while serving r.
+func Panic(r *http.Request) {
+	route := route(r)
+	if matched, ok := r.Context().Value(matchedKey{}).(*string); ok && *matched != "" {
+		route = *matched
+	}
+	panics.WithLabelValues(route).Inc()
+}
+
+// QueueDepth exports the number of documents an ingest queue has not written yet.
+func QueueDepth(pending func() int) {
+	Registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "ingest_queue_depth",
+		Help: "Documents waiting in the ingest queue to be written to the storage backend.",
+	}, func() float64 {
+		return float64(pending())
+	}))
+}
@@ -0,0 +1,111 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"sofa-logs-servers/infra/storage"
+	"strings"
+	"time"
+)
+
+var _ storage.Storage = (*Store)(nil)
+
+// Store times every call to a storage backend and counts the documents it writes.
+type Store struct {
+	storage.Storage
+	indices []string
+}
+
+// NewStore labels documents with the one of indices they were written to, whichever tenant's
+// copy of it that was, so the number of series does not grow with the tenants.
+func NewStore(store storage.Storage, indices ...string) *Store {
+	return &Store{Storage: store, indices: indices}
+}
+
+// label returns the index a tenant index is a copy of, the longest match winning, or "other".
+func (s *Store) label(index string) string {
+	label := "other"
+	for _, name := range s.indices {
+		if (index == name || strings.HasSuffix(index, "-"+name)) && (label == "other" || len(name) > len(label)) {
+			label = name
+		}
+	}
+	return label
+}
+
+// observe records a call to operation that started at start. Missing documents are an answer,
+// not a failure of the backend.
+func observe(operation string, start time.Time, err error) {
+	storageDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		storageErrors.WithLabelValues(operation).Inc()
+	}
+}
+
+func (s *Store) Index(ctx context.Context, index string, document interface{}) (storage.IndexResult, error) {
+	start := time.Now()
+	result, err := s.Storage.Index(ctx, index, document)
+	observe("index", start, err)
+
+	if err == nil {
+		documentsIndexed.WithLabelValues(s.label(index)).Inc()
+	}
+	return result, err
+}
+
+func (s *Store) Update(ctx context.Context, index, id string, document interface{}) error {
+	start := time.Now()
+	err := s.Storage.Update(ctx, index, id, document)
+	observe("update", start, err)
+	return err
+}
+
+func (s *Store) Delete(ctx context.Context, index, id string) error {
+	start := time.Now()
+	err := s.Storage.Delete(ctx, index, id)
+	observe("delete", start, err)
+	return err
+}
+
+func (s *Store) Get(ctx context.Context, index, id string) (storage.Hit, error) {
+	start := time.Now()
+	hit, err := s.Storage.Get(ctx, index, id)
+	observe("get", start, err)
+	return hit, err
+}
+
+func (s *Store) Search(ctx context.Context, index string, query storage.Query) (storage.SearchResult, error) {
+	start := time.Now()
+	result, err := s.Storage.Search(ctx, index, query)
+	observe("search", start, err)
+	return result, err
+}
+
+func (s *Store) Bulk(ctx context.Context, index string, documents []storage.Document) ([]storage.BulkItem, error) {
+	start := time.Now()
+	items, err := s.Storage.Bulk(ctx, index, documents)
+	observe("bulk", start, err)
+
+	written := 0
+	for _, item := range items {
+		if item.Err == nil {
+			written++
+		}
+	}
+	documentsIndexed.WithLabelValues(s.label(index)).Add(float64(written))
+	return items, err
+}
+
+func (s *Store) EnsureIndex(ctx context.Context, index string, mapping storage.Mapping) error {
+	start := time.Now()
+	err := s.Storage.EnsureIndex(ctx, index, mapping)
+	observe("ensure_index", start, err)
+	return err
+}
+
+func (s *Store) IndexExists(ctx context.Context, index string) (bool, error) {
+	start := time.Now()
+	exists, err := s.Storage.IndexExists(ctx, index)
+	observe("index_exists", start, err)
+	return exists, err
+}
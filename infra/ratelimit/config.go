@@ -0,0 +1,138 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// KeyBy names what requests share a bucket.
+type KeyBy string
+
+const (
+	// ByKey gives every API key or token its own bucket, and requests without credentials one per
+	// client IP.
+	ByKey    KeyBy = "key"
+	ByTenant KeyBy = "tenant"
+	ByIP     KeyBy = "ip"
+)
+
+type Limit struct {
+	// Rate is the number of requests a second a bucket refills with.
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+	By    KeyBy   `json:"by"`
+}
+
+// DefaultIPLimit applies to each client IP when the rate limit file sets no ip limit.
+var DefaultIPLimit = Limit{Rate: 100, Burst: 200, By: ByIP}
+
+// Config is the content of the rate limit file, like
+//
+//	{
+//	  "ip": {"rate": 100, "burst": 200},
+//	  "default": {"rate": 20, "burst": 40, "by": "key"},
+//	  "routes": {"/api/logs/create": {"rate": 100, "burst": 200, "by": "tenant"}},
+//	  "quota": {"daily": 1000000, "tenants": {"acme": 5000000}, "file": "quota.json"}
+//	}
+//
+// Routes without a limit of their own use the default one, and without a default they are not
+// limited. The ip limit is checked on every route before credentials, so requests with made up
+// credentials are limited as well.
+type Config struct {
+	IP      *Limit           `json:"ip"`
+	Default *Limit           `json:"default"`
+	Routes  map[string]Limit `json:"routes"`
+	Quota   QuotaConfig      `json:"quota"`
+}
+
+// Init reads the rate limit file at path. Without one nothing is limited.
+func Init(path string) (Config, error) {
+	if path == "" {
+		return Config{}, nil
+	}
+	return ReadConfig(path)
+}
+
+func ReadConfig(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+
+	config := Config{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return Config{}, fmt.Errorf("ratelimit: %s: %w", path, err)
+	}
+
+	limits := map[string]Limit{}
+	for route, limit := range config.Routes {
+		limits[route] = limit
+	}
+	if config.Default != nil {
+		limits["default"] = *config.Default
+	}
+	if config.IP != nil {
+		limits["ip"] = *config.IP
+	}
+
+	for name, limit := range limits {
+		if limit.Rate <= 0 {
+			return Config{}, fmt.Errorf("ratelimit: %s: %s: rate must be positive", path, name)
+		}
+		switch limit.By {
+		case "", ByKey, ByTenant, ByIP:
+		default:
+			return Config{}, fmt.Errorf("ratelimit: %s: %s: unknown by %q", path, name, limit.By)
+		}
+	}
+	return config, nil
+}
+
+// Limits hands out the limiter of each route, created on first use.
+type Limits struct {
+	config Config
+	ip     *Limiter
+
+	mu       sync.Mutex
+	limiters map[string]*Limiter
+}
+
+func NewLimits(config Config) *Limits {
+	ip := DefaultIPLimit
+	if config.IP != nil {
+		ip = *config.IP
+	}
+	return &Limits{config: config, ip: NewLimiter(ip.Rate, ip.Burst), limiters: map[string]*Limiter{}}
+}
+
+// IP returns the limiter every request is checked against by client IP.
+func (l *Limits) IP() *Limiter {
+	return l.ip
+}
+
+// For returns the limiter of route and what its buckets are keyed by, or nil when the route is not
+// limited.
+func (l *Limits) For(route string) (*Limiter, KeyBy) {
+	limit, ok := l.config.Routes[route]
+	if !ok {
+		if l.config.Default == nil {
+			return nil, ""
+		}
+		limit = *l.config.Default
+	}
+	if limit.By == "" {
+		limit.By = ByKey
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	limiter, ok := l.limiters[route]
+	if !ok {
+		limiter = NewLimiter(limit.Rate, limit.Burst)
+		l.limiters[route] = limiter
+	}
+	return limiter, limit.By
+}
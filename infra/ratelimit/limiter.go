@@ -0,0 +1,72 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// idleAfter is how long a full bucket is kept after its last use before it is dropped.
+const idleAfter = 10 * time.Minute
+
+// Limiter is a set of token buckets, one per key, that refill at Rate tokens a second up to Burst.
+type Limiter struct {
+	rate  float64
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func NewLimiter(rate float64, burst int) *Limiter {
+	if burst < 1 {
+		burst = int(math.Max(1, math.Ceil(rate)))
+	}
+	return &Limiter{rate: rate, burst: float64(burst), buckets: map[string]*bucket{}, lastSweep: time.Now()}
+}
+
+// Allow takes a token from the bucket of key. When it is empty it returns false and how long
+// until a token is available.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+// sweep drops the buckets that have been idle long enough to be full again.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < idleAfter {
+		return
+	}
+	l.lastSweep = now
+
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > idleAfter {
+			delete(l.buckets, key)
+		}
+	}
+}
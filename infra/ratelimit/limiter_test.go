@@ -0,0 +1,78 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAllow(t *testing.T) {
+	limiter := NewLimiter(1, 2)
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := limiter.Allow("a"); !ok {
+			t.Fatalf("request %d within the burst refused", i)
+		}
+	}
+	ok, retryAfter := limiter.Allow("a")
+	if ok {
+		t.Fatal("request past the burst allowed")
+	}
+	if retryAfter <= 0 || retryAfter > time.Second {
+		t.Errorf("retry after %v, want up to a second", retryAfter)
+	}
+
+	// Buckets are kept per key.
+	if ok, _ := limiter.Allow("b"); !ok {
+		t.Error("another key shares the empty bucket")
+	}
+}
+
+func TestAllowRefills(t *testing.T) {
+	limiter := NewLimiter(1000, 1)
+	if ok, _ := limiter.Allow("a"); !ok {
+		t.Fatal("first request refused")
+	}
+	if ok, _ := limiter.Allow("a"); ok {
+		t.Fatal("second request allowed at once")
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	if ok, _ := limiter.Allow("a"); !ok {
+		t.Error("bucket did not refill")
+	}
+}
+
+func TestLimitsFor(t *testing.T) {
+	config := Config{
+		Default: &Limit{Rate: 1, Burst: 1},
+		Routes:  map[string]Limit{"/api/logs/create": {Rate: 10, Burst: 10, By: ByTenant}},
+	}
+	limits := NewLimits(config)
+
+	tests := []struct {
+		route string
+		by    KeyBy
+		burst float64
+	}{
+		{"/api/logs/create", ByTenant, 10},
+		{"/api/logs/all", ByKey, 1},
+	}
+	for _, test := range tests {
+		limiter, by := limits.For(test.route)
+		if limiter == nil || by != test.by || limiter.burst != test.burst {
+			t.Errorf("%s: limiter %+v by %q, want burst %v by %q", test.route, limiter, by, test.burst, test.by)
+		}
+	}
+
+	first, _ := limits.For("/api/logs/all")
+	second, _ := limits.For("/api/logs/all")
+	if first != second {
+		t.Error("a route gets a new limiter on every request")
+	}
+	if limiter, _ := NewLimits(Config{}).For("/api/logs/all"); limiter != nil {
+		t.Error("route limited without a default limit")
+	}
+	if burst := NewLimits(Config{}).IP().burst; burst != float64(DefaultIPLimit.Burst) {
+		t.Errorf("ip burst = %v without an ip limit, want the default", burst)
+	}
+}
@@ -0,0 +1,253 @@
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sofa-logs-servers/infra/storage"
+	"sofa-logs-servers/infra/tenant"
+	"sync"
+	"time"
+)
+
+// saveInterval is how often quota counts are written to the quota file.
+const saveInterval = 10 * time.Second
+
+var ErrQuotaExceeded = errors.New("daily document quota exceeded")
+
+// QuotaError is returned when a tenant has used up its documents for the day.
+type QuotaError struct {
+	Tenant string
+	Limit  int64
+	// RetryAfter is the time left until the quota resets at midnight UTC.
+	RetryAfter time.Duration
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("tenant %q: %v (%d a day)", e.Tenant, ErrQuotaExceeded, e.Limit)
+}
+
+func (e *QuotaError) Is(target error) bool {
+	return target == ErrQuotaExceeded
+}
+
+type QuotaConfig struct {
+	// Daily is the number of documents a tenant may store a day, 0 for no limit.
+	Daily int64 `json:"daily"`
+	// Tenants overrides Daily for single tenants, the default tenant being "".
+	Tenants map[string]int64 `json:"tenants"`
+	// File keeps the counts of the day across restarts when set.
+	File string `json:"file"`
+}
+
+// Quota counts the documents each tenant stores a day, in memory.
+type Quota struct {
+	config QuotaConfig
+
+	mu     sync.Mutex
+	day    string
+	counts map[string]int64
+	dirty  bool
+
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// quotaState is the content of the quota file.
+type quotaState struct {
+	Day    string           `json:"day"`
+	Counts map[string]int64 `json:"counts"`
+}
+
+// OpenQuota loads today's counts from config.File, if set, and keeps saving them there.
+func OpenQuota(config QuotaConfig) (*Quota, error) {
+	q := &Quota{
+		config:  config,
+		day:     today(time.Now()),
+		counts:  map[string]int64{},
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+
+	if config.File == "" {
+		close(q.stopped)
+		return q, nil
+	}
+
+	data, err := os.ReadFile(config.File)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		state := quotaState{}
+		if err := json.Unmarshal(data, &state); err != nil {
+			return nil, fmt.Errorf("ratelimit: %s: %w", config.File, err)
+		}
+		if state.Day == q.day && state.Counts != nil {
+			q.counts = state.Counts
+		}
+	}
+
+	go q.run()
+	return q, nil
+}
+
+func today(now time.Time) string {
+	return now.UTC().Format("2006-01-02")
+}
+
+func (q *Quota) limit(tenantID string) int64 {
+	if limit, ok := q.config.Tenants[tenantID]; ok {
+		return limit
+	}
+	return q.config.Daily
+}
+
+// Take counts n documents against the quota of tenantID, or none of them if they do not all fit.
+func (q *Quota) Take(tenantID string, n int) error {
+	now := time.Now()
+	limit := q.limit(tenantID)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if day := today(now); day != q.day {
+		q.day = day
+		q.counts = map[string]int64{}
+	}
+
+	if limit > 0 && q.counts[tenantID]+int64(n) > limit {
+		year, month, date := now.UTC().Date()
+		midnight := time.Date(year, month, date+1, 0, 0, 0, 0, time.UTC)
+		return &QuotaError{Tenant: tenantID, Limit: limit, RetryAfter: midnight.Sub(now)}
+	}
+
+	q.counts[tenantID] += int64(n)
+	q.dirty = true
+	return nil
+}
+
+// Give returns n documents taken today to the quota of tenantID, when the store refused them.
+func (q *Quota) Give(tenantID string, n int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if n <= 0 || today(time.Now()) != q.day {
+		return
+	}
+	q.counts[tenantID] -= min(int64(n), q.counts[tenantID])
+	q.dirty = true
+}
+
+func (q *Quota) run() {
+	defer close(q.stopped)
+
+	ticker := time.NewTicker(saveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-q.done:
+			return
+		case <-ticker.C:
+			if err := q.save(); err != nil {
+				slog.Error("saving quota counts failed", "file", q.config.File, "err", err)
+			}
+		}
+	}
+}
+
+// save writes the counts to the quota file if they changed since the last save.
+func (q *Quota) save() error {
+	q.mu.Lock()
+	if !q.dirty {
+		q.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(quotaState{Day: q.day, Counts: q.counts})
+	q.dirty = false
+	q.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	err = storage.ReplaceFile(q.config.File, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+	if err != nil {
+		q.mu.Lock()
+		q.dirty = true
+		q.mu.Unlock()
+	}
+	return err
+}
+
+// Close stops saving in the background and saves the counts a last time.
+func (q *Quota) Close() error {
+	select {
+	case <-q.done:
+		return nil
+	default:
+		close(q.done)
+	}
+	<-q.stopped
+
+	if q.config.File == "" {
+		return nil
+	}
+	return q.save()
+}
+
+var _ storage.Storage = (*QuotaStore)(nil)
+
+// QuotaStore counts the documents stored through Index and Bulk against the quota of the tenant
+// carried by the context, and refuses them with a *QuotaError once it is used up. Documents the
+// store fails to take are given back.
+type QuotaStore struct {
+	storage.Storage
+	quota *Quota
+}
+
+func NewQuotaStore(store storage.Storage, quota *Quota) *QuotaStore {
+	return &QuotaStore{Storage: store, quota: quota}
+}
+
+func (s *QuotaStore) Index(ctx context.Context, index string, document interface{}) (storage.IndexResult, error) {
+	tenantID := tenant.FromContext(ctx)
+	if err := s.quota.Take(tenantID, 1); err != nil {
+		return storage.IndexResult{}, err
+	}
+
+	res, err := s.Storage.Index(ctx, index, document)
+	if err != nil {
+		s.quota.Give(tenantID, 1)
+	}
+	return res, err
+}
+
+func (s *QuotaStore) Bulk(ctx context.Context, index string, documents []storage.Document) ([]storage.BulkItem, error) {
+	tenantID := tenant.FromContext(ctx)
+	if err := s.quota.Take(tenantID, len(documents)); err != nil {
+		return nil, err
+	}
+
+	items, err := s.Storage.Bulk(ctx, index, documents)
+	if err != nil {
+		s.quota.Give(tenantID, len(documents))
+		return items, err
+	}
+
+	failed := 0
+	for _, item := range items {
+		if item.Err != nil {
+			failed++
+		}
+	}
+	s.quota.Give(tenantID, failed)
+	return items, nil
+}
@@ -0,0 +1,154 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sofa-logs-servers/infra/storage"
+	"sofa-logs-servers/infra/tenant"
+	"testing"
+)
+
+func openQuota(t *testing.T, config QuotaConfig) *Quota {
+	t.Helper()
+	q, err := OpenQuota(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = q.Close() })
+	return q
+}
+
+func TestTakeAndGive(t *testing.T) {
+	q := openQuota(t, QuotaConfig{Daily: 10, Tenants: map[string]int64{"acme": 3, "unlimited": 0}})
+
+	steps := []struct {
+		tenant string
+		take   int
+		give   int
+		ok     bool
+	}{
+		{"", 8, 0, true},
+		{"", 3, 0, false}, // all or nothing
+		{"", 2, 0, true},
+		{"", 1, 0, false},
+		{"", 0, 5, true}, // refunded
+		{"", 5, 0, true},
+		{"acme", 3, 0, true},
+		{"acme", 1, 0, false},
+		{"acme", 0, 10, true}, // never below zero
+		{"acme", 3, 0, true},
+		{"acme", 1, 0, false},
+		{"unlimited", 1000, 0, true},
+	}
+
+	for i, step := range steps {
+		if step.give > 0 {
+			q.Give(step.tenant, step.give)
+			continue
+		}
+
+		err := q.Take(step.tenant, step.take)
+		if step.ok != (err == nil) {
+			t.Fatalf("step %d: take %d for %q: err = %v", i, step.take, step.tenant, err)
+		}
+		var quotaErr *QuotaError
+		if err != nil && (!errors.As(err, &quotaErr) || !errors.Is(err, ErrQuotaExceeded) || quotaErr.RetryAfter <= 0) {
+			t.Errorf("step %d: err = %#v", i, err)
+		}
+	}
+}
+
+func TestQuotaFile(t *testing.T) {
+	config := QuotaConfig{Daily: 5, File: filepath.Join(t.TempDir(), "quota.json")}
+
+	q := openQuota(t, config)
+	if err := q.Take("acme", 4); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	q = openQuota(t, config)
+	if err := q.Take("acme", 2); err == nil {
+		t.Error("counts lost across a restart")
+	}
+	if err := q.Take("acme", 1); err != nil {
+		t.Error(err)
+	}
+}
+
+// backend fails the documents whose id it is given.
+type backend struct {
+	storage.Storage
+	fail    map[string]bool
+	refused bool
+}
+
+func (b backend) Index(context.Context, string, interface{}) (storage.IndexResult, error) {
+	if b.refused {
+		return storage.IndexResult{}, storage.ErrUnavailable
+	}
+	return storage.IndexResult{ID: "id"}, nil
+}
+
+func (b backend) Bulk(_ context.Context, _ string, documents []storage.Document) ([]storage.BulkItem, error) {
+	if b.refused {
+		return nil, storage.ErrUnavailable
+	}
+	items := make([]storage.BulkItem, len(documents))
+	for i, document := range documents {
+		if b.fail[document.ID] {
+			items[i].Err = errors.New("rejected")
+		}
+	}
+	return items, nil
+}
+
+func TestQuotaStoreRefunds(t *testing.T) {
+	documents := []storage.Document{{ID: "a"}, {ID: "b"}, {ID: "c"}}
+	tests := []struct {
+		name    string
+		backend backend
+		call    func(s *QuotaStore, ctx context.Context) error
+		left    int
+	}{
+		{"indexed", backend{}, func(s *QuotaStore, ctx context.Context) error {
+			_, err := s.Index(ctx, "requests", nil)
+			return err
+		}, 4},
+		{"index failed", backend{refused: true}, func(s *QuotaStore, ctx context.Context) error {
+			_, err := s.Index(ctx, "requests", nil)
+			return err
+		}, 5},
+		{"bulk", backend{}, func(s *QuotaStore, ctx context.Context) error {
+			_, err := s.Bulk(ctx, "requests", documents)
+			return err
+		}, 2},
+		{"bulk items failed", backend{fail: map[string]bool{"a": true, "c": true}}, func(s *QuotaStore, ctx context.Context) error {
+			_, err := s.Bulk(ctx, "requests", documents)
+			return err
+		}, 4},
+		{"bulk failed", backend{refused: true}, func(s *QuotaStore, ctx context.Context) error {
+			_, err := s.Bulk(ctx, "requests", documents)
+			return err
+		}, 5},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			q := openQuota(t, QuotaConfig{Daily: 5})
+			store := NewQuotaStore(test.backend, q)
+
+			_ = test.call(store, tenant.WithTenant(context.Background(), "acme"))
+
+			if err := q.Take("acme", test.left); err != nil {
+				t.Errorf("less than %d documents left: %v", test.left, err)
+			}
+			if err := q.Take("acme", 1); err == nil {
+				t.Errorf("more than %d documents left", test.left)
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// ErrUnsupported is returned when a query asks for something the backend cannot compute.
+var ErrUnsupported = errors.New("not supported by this storage backend")
+
+type AggregationType string
+
+const (
+	AggTerms       AggregationType = "terms"
+	AggCardinality AggregationType = "cardinality"
+	AggAvg         AggregationType = "avg"
+	AggSum         AggregationType = "sum"
+	AggMin         AggregationType = "min"
+	AggMax         AggregationType = "max"
+	AggPercentiles AggregationType = "percentiles"
+	AggRange       AggregationType = "range"
+	// AggDateHistogram buckets documents by calendar Interval of a date field in TimeZone.
+	AggDateHistogram AggregationType = "date_histogram"
+)
+
+// Interval is the calendar unit of a date histogram. Weeks start on Monday.
+type Interval string
+
+const (
+	IntervalHour  Interval = "hour"
+	IntervalDay   Interval = "day"
+	IntervalWeek  Interval = "week"
+	IntervalMonth Interval = "month"
+)
+
+// Truncate returns the start of the interval t falls in, in the location of t.
+func (i Interval) Truncate(t time.Time) time.Time {
+	year, month, day := t.Date()
+	switch i {
+	case IntervalHour:
+		return time.Date(year, month, day, t.Hour(), 0, 0, 0, t.Location())
+	case IntervalWeek:
+		return time.Date(year, month, day-(int(t.Weekday())+6)%7, 0, 0, 0, 0, t.Location())
+	case IntervalMonth:
+		return time.Date(year, month, 1, 0, 0, 0, 0, t.Location())
+	default:
+		return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+	}
+}
+
+// Next returns the start of the interval following the one starting at t.
+func (i Interval) Next(t time.Time) time.Time {
+	switch i {
+	case IntervalHour:
+		return t.Add(time.Hour)
+	case IntervalWeek:
+		return t.AddDate(0, 0, 7)
+	case IntervalMonth:
+		return t.AddDate(0, 1, 0)
+	default:
+		return t.AddDate(0, 0, 1)
+	}
+}
+
+// Aggregation summarises the documents a query matches. Bucket aggregations (terms, range and
+// date histogram) can nest aggregations that are computed for each bucket.
+type Aggregation struct {
+	Type  AggregationType
+	Field string
+	// Size is the number of buckets a terms aggregation keeps, most frequent first.
+	Size int
+	// Percents lists the percentiles to compute, like 50 and 95.
+	Percents []float64
+	Ranges   []AggregationRange
+	Interval Interval
+	// TimeZone is the IANA name of the zone date histogram buckets start in, UTC when empty.
+	TimeZone string
+	Aggs     map[string]Aggregation
+}
+
+// AggregationRange is one bucket of a range aggregation. From is inclusive, To exclusive and
+// nil leaves that side open.
+type AggregationRange struct {
+	From *float64
+	To   *float64
+}
+
+type AggregationResult struct {
+	// Value is the result of a metric aggregation, nil when no document had the field.
+	Value       *float64
+	Percentiles map[float64]float64
+	Buckets     []Bucket
+}
+
+// Bucket is one group of a bucket aggregation. Date histogram keys are the bucket start in
+// milliseconds since the epoch.
+type Bucket struct {
+	Key   interface{}
+	Count int
+	Aggs  map[string]AggregationResult
+}
+
+// DecodeAggregations reads aggregation results in the Elasticsearch response format, which Zinc
+// follows as well apart from nesting sub-aggregations under an "aggregations" key.
+func DecodeAggregations(aggs map[string]Aggregation, raw map[string]json.RawMessage) (map[string]AggregationResult, error) {
+	results := make(map[string]AggregationResult, len(aggs))
+	for name, agg := range aggs {
+		data, ok := raw[name]
+		if !ok {
+			return nil, fmt.Errorf("storage: aggregation %s missing from response", name)
+		}
+
+		decoded := struct {
+			Value   *float64                     `json:"value"`
+			Values  map[string]*float64          `json:"values"`
+			Buckets []map[string]json.RawMessage `json:"buckets"`
+		}{}
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			return nil, fmt.Errorf("storage: aggregation %s: %w", name, err)
+		}
+
+		result := AggregationResult{Value: decoded.Value}
+
+		if agg.Type == AggPercentiles {
+			result.Value = nil
+			result.Percentiles = map[float64]float64{}
+			for key, value := range decoded.Values {
+				percent, err := strconv.ParseFloat(key, 64)
+				if err != nil || value == nil {
+					continue
+				}
+				result.Percentiles[percent] = *value
+			}
+		}
+
+		for _, rawBucket := range decoded.Buckets {
+			bucket, err := decodeBucket(agg.Aggs, rawBucket)
+			if err != nil {
+				return nil, err
+			}
+			result.Buckets = append(result.Buckets, bucket)
+		}
+
+		results[name] = result
+	}
+	return results, nil
+}
+
+func decodeBucket(aggs map[string]Aggregation, raw map[string]json.RawMessage) (Bucket, error) {
+	bucket := Bucket{}
+	if err := json.Unmarshal(raw["key"], &bucket.Key); err != nil {
+		return Bucket{}, err
+	}
+	if err := json.Unmarshal(raw["doc_count"], &bucket.Count); err != nil {
+		return Bucket{}, err
+	}
+
+	if len(aggs) == 0 {
+		return bucket, nil
+	}
+
+	if nested, ok := raw["aggregations"]; ok {
+		raw = map[string]json.RawMessage{}
+		if err := json.Unmarshal(nested, &raw); err != nil {
+			return Bucket{}, err
+		}
+	}
+
+	var err error
+	bucket.Aggs, err = DecodeAggregations(aggs, raw)
+	return bucket, err
+}
+
+// Percentiles computes exact percentiles of values, interpolating linearly between the closest
+// ranks. It sorts values in place.
+func Percentiles(values []float64, percents []float64) map[float64]float64 {
+	result := map[float64]float64{}
+	if len(values) == 0 {
+		return result
+	}
+
+	sort.Float64s(values)
+	for _, percent := range percents {
+		rank := percent / 100 * float64(len(values)-1)
+		lower := int(math.Floor(rank))
+		upper := int(math.Ceil(rank))
+		result[percent] = values[lower] + (values[upper]-values[lower])*(rank-float64(lower))
+	}
+	return result
+}
@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// ReplaceFile writes a new version of the file at path with write, next to it, and renames it over
+// the old one once it is on disk. A crash leaves either the old or the new file whole, never an
+// empty or half written one.
+func ReplaceFile(path string, write func(w io.Writer) error) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	// Once renamed the temporary file is gone and this fails harmlessly.
+	defer os.Remove(tmp.Name())
+
+	buffered := bufio.NewWriter(tmp)
+	err = write(buffered)
+	if err == nil {
+		err = buffered.Flush()
+	}
+	if err == nil {
+		err = tmp.Chmod(0o644)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir makes a rename in dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if closeErr := d.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,196 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned by Get when no document matches the given id.
+	ErrNotFound = errors.New("document not found")
+	// ErrUnavailable is returned when the store cannot take the request right now.
+	ErrUnavailable = errors.New("storage unavailable")
+	// ErrConflict is returned when a write lost a race with another write of the same document.
+	ErrConflict = errors.New("document conflict")
+)
+
+// BackendError relates err, the failure of a call a backend answered with status, or zero when it
+// did not answer, to the errors of this package. Errors of the caller's context are kept as is.
+func BackendError(status int, err error) error {
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return err
+	case status == 0, status == http.StatusServiceUnavailable, status == http.StatusTooManyRequests:
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	case status == http.StatusConflict:
+		return fmt.Errorf("%w: %v", ErrConflict, err)
+	case status == http.StatusNotFound:
+		return fmt.Errorf("%w: %v", ErrNotFound, err)
+	}
+	return err
+}
+
+const (
+	ResultCreated = "created"
+	// ResultQueued means the document was accepted but is not written to the backend yet.
+	ResultQueued = "queued"
+)
+
+// Storage is the document store the route handlers run against.
+type Storage interface {
+	// Index stores a new document and returns the id the store assigned to it.
+	Index(ctx context.Context, index string, document interface{}) (IndexResult, error)
+	// Update replaces the document stored under id.
+	Update(ctx context.Context, index, id string, document interface{}) error
+	Delete(ctx context.Context, index, id string) error
+	Get(ctx context.Context, index, id string) (Hit, error)
+	Search(ctx context.Context, index string, query Query) (SearchResult, error)
+	// Bulk indexes several documents in one round trip. The returned items follow the order of
+	// documents, an error is only returned when the request as a whole failed.
+	Bulk(ctx context.Context, index string, documents []Document) ([]BulkItem, error)
+	// IndexExists reports whether index has been created. Errors mean the backend could not answer.
+	IndexExists(ctx context.Context, index string) (bool, error)
+	// EnsureIndex creates the index with the given mapping unless it already exists.
+	EnsureIndex(ctx context.Context, index string, mapping Mapping) error
+}
+
+type FieldType string
+
+const (
+	FieldKeyword FieldType = "keyword"
+	FieldText    FieldType = "text"
+	FieldDate    FieldType = "date"
+	FieldLong    FieldType = "long"
+	FieldDouble  FieldType = "double"
+)
+
+// Mapping maps the field names of an index to their type.
+type Mapping map[string]FieldType
+
+type IndexResult struct {
+	ID     string
+	Result string
+}
+
+// Document is one entry of a bulk request. The backend assigns an id when ID is empty, otherwise
+// the document is written under ID, replacing any previous version.
+type Document struct {
+	ID     string
+	Source interface{}
+}
+
+// BulkItem is the outcome of one document of a bulk request.
+type BulkItem struct {
+	ID     string
+	Result string
+	Err    error
+}
+
+type SortField struct {
+	Field string
+	Desc  bool
+}
+
+// Range matches documents whose field lies within the set bounds. Nil bounds are left open.
+type Range struct {
+	Field string
+	Gt    interface{}
+	Gte   interface{}
+	Lt    interface{}
+	Lte   interface{}
+}
+
+// Term matches documents whose field equals Value exactly.
+type Term struct {
+	Field string
+	Value interface{}
+}
+
+// Pattern matches string fields. For wildcards * stands for any run of characters and ? for a
+// single one.
+type Pattern struct {
+	Field string
+	Value string
+}
+
+// Filter restricts the documents a query matches. Every clause that is set must hold, so the zero
+// value matches every document. And and Or combine nested filters.
+type Filter struct {
+	And      []Filter
+	Or       []Filter
+	Term     *Term
+	Prefix   *Pattern
+	Wildcard *Pattern
+	Range    *Range
+}
+
+// Query describes a search independently of the backend. The zero value matches every document.
+type Query struct {
+	Filter *Filter
+	Sort   []SortField
+	// Size caps the number of returned hits, zero leaves it to the backend default.
+	Size int
+	// After continues a previous search right after the hit whose Sort values it holds.
+	After []interface{}
+	// Aggs are computed over every matching document. A query with aggregations returns no hits
+	// unless Size is set.
+	Aggs map[string]Aggregation
+}
+
+type Hit struct {
+	Index     string          `json:"_index"`
+	ID        string          `json:"_id"`
+	Score     float64         `json:"_score"`
+	Timestamp time.Time       `json:"@timestamp"`
+	Source    json.RawMessage `json:"_source"`
+	// Sort holds backend specific values that can be passed back as Query.After.
+	Sort []interface{} `json:"sort,omitempty"`
+}
+
+type SearchResult struct {
+	Total        int
+	Hits         []Hit
+	Aggregations map[string]AggregationResult
+}
+
+// ScanSize is the page size SearchAll walks an index with.
+const ScanSize = 1000
+
+// SearchAll collects every hit matching query, paging through the results instead of relying on
+// the size limit of a single search.
+func SearchAll(ctx context.Context, store Storage, index string, query Query) ([]Hit, error) {
+	query.Size = ScanSize
+
+	var hits []Hit
+	for {
+		res, err := store.Search(ctx, index, query)
+		if err != nil {
+			return nil, err
+		}
+
+		hits = append(hits, res.Hits...)
+		if len(res.Hits) < query.Size {
+			return hits, nil
+		}
+
+		query.After = res.Hits[len(res.Hits)-1].Sort
+		if len(query.After) == 0 {
+			return nil, errors.New("storage: backend returned no sort values to page with")
+		}
+	}
+}
+
+// ToMap converts a document to the generic map form most backend clients expect.
+func ToMap(document interface{}) (map[string]interface{}, error) {
+	raw, err := json.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := map[string]interface{}{}
+	return doc, json.Unmarshal(raw, &doc)
+}
@@ -0,0 +1,165 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"sofa-logs-servers/infra/storage"
+	"strings"
+	"sync"
+)
+
+// Header selects the tenant of a request whose credentials are not bound to one.
+const Header = "X-Tenant-ID"
+
+var ErrInvalid = errors.New("tenant ids are 1 to 63 lowercase letters, digits, '-' or '_', starting with a letter or digit")
+
+// validID keeps tenant ids usable as a prefix of index names on every backend.
+var validID = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+func Validate(id string) error {
+	if !validID.MatchString(id) {
+		return ErrInvalid
+	}
+	return nil
+}
+
+type contextKey struct{}
+
+// WithTenant returns a copy of ctx whose storage calls go to the indices of tenant id.
+func WithTenant(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the tenant of ctx, empty for the default tenant.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// IndexName returns the index that holds index for tenant id. The default tenant keeps the
+// unprefixed indices.
+func IndexName(id, index string) string {
+	if id == "" {
+		return index
+	}
+	return id + "-" + index
+}
+
+// Collide reports whether the indices of tenants can be named alike when a and b are both in
+// use. With a = "acme-" + b, IndexName("acme", b) is a itself, and IndexName("t-acme", b) is
+// IndexName("t", a).
+func Collide(a, b string) bool {
+	return strings.HasSuffix(a, "-"+b) || strings.HasSuffix(b, "-"+a)
+}
+
+var _ storage.Storage = (*Store)(nil)
+
+// Store sends every call to the indices of the tenant carried by its context, so a tenant never
+// reads or changes the documents of another. The indices of a tenant are created on first use
+// with the mapping given to EnsureIndex for the unprefixed index.
+//
+// Handlers name indices by what they hold, like "requests"; names maps those to the indices
+// configured for them. Indices missing from names keep their own name.
+type Store struct {
+	storage.Storage
+
+	names    map[string]string
+	mu       sync.Mutex
+	mappings map[string]storage.Mapping
+	ensured  map[string]bool
+}
+
+func New(store storage.Storage, names map[string]string) *Store {
+	return &Store{Storage: store, names: names, mappings: map[string]storage.Mapping{}, ensured: map[string]bool{}}
+}
+
+// name returns the index of the tenant of ctx that holds index.
+func (s *Store) name(ctx context.Context, index string) string {
+	if configured, ok := s.names[index]; ok {
+		index = configured
+	}
+	return IndexName(FromContext(ctx), index)
+}
+
+// index returns the index of the tenant of ctx, creating it when this is its first use.
+func (s *Store) index(ctx context.Context, index string) (string, error) {
+	name := s.name(ctx, index)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	mapping, ok := s.mappings[index]
+	if !ok || s.ensured[name] {
+		return name, nil
+	}
+
+	err := s.Storage.EnsureIndex(ctx, name, mapping)
+	if err != nil {
+		return "", err
+	}
+	s.ensured[name] = true
+	return name, nil
+}
+
+func (s *Store) Index(ctx context.Context, index string, document interface{}) (storage.IndexResult, error) {
+	name, err := s.index(ctx, index)
+	if err != nil {
+		return storage.IndexResult{}, err
+	}
+	return s.Storage.Index(ctx, name, document)
+}
+
+func (s *Store) Update(ctx context.Context, index, id string, document interface{}) error {
+	name, err := s.index(ctx, index)
+	if err != nil {
+		return err
+	}
+	return s.Storage.Update(ctx, name, id, document)
+}
+
+func (s *Store) Delete(ctx context.Context, index, id string) error {
+	name, err := s.index(ctx, index)
+	if err != nil {
+		return err
+	}
+	return s.Storage.Delete(ctx, name, id)
+}
+
+func (s *Store) Get(ctx context.Context, index, id string) (storage.Hit, error) {
+	name, err := s.index(ctx, index)
+	if err != nil {
+		return storage.Hit{}, err
+	}
+	return s.Storage.Get(ctx, name, id)
+}
+
+func (s *Store) Search(ctx context.Context, index string, query storage.Query) (storage.SearchResult, error) {
+	name, err := s.index(ctx, index)
+	if err != nil {
+		return storage.SearchResult{}, err
+	}
+	return s.Storage.Search(ctx, name, query)
+}
+
+func (s *Store) Bulk(ctx context.Context, index string, documents []storage.Document) ([]storage.BulkItem, error) {
+	name, err := s.index(ctx, index)
+	if err != nil {
+		return nil, err
+	}
+	return s.Storage.Bulk(ctx, name, documents)
+}
+
+// EnsureIndex records mapping for index and creates the index of the tenant of ctx.
+func (s *Store) EnsureIndex(ctx context.Context, index string, mapping storage.Mapping) error {
+	s.mu.Lock()
+	s.mappings[index] = mapping
+	s.mu.Unlock()
+
+	_, err := s.index(ctx, index)
+	return err
+}
+
+func (s *Store) IndexExists(ctx context.Context, index string) (bool, error) {
+	return s.Storage.IndexExists(ctx, s.name(ctx, index))
+}
@@ -0,0 +1,59 @@
+package tenant
+
+import (
+	"context"
+	"sofa-logs-servers/infra/embedded"
+	"sofa-logs-servers/infra/storage"
+	"testing"
+)
+
+func TestCollide(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"requests", "transactions", false},
+		{"acme-requests", "requests", true},
+		{"requests", "acme-requests", true},
+		{"acme_requests", "requests", false},
+		{"logs-requests", "transactions", false},
+	}
+
+	for _, test := range tests {
+		if got := Collide(test.a, test.b); got != test.want {
+			t.Errorf("Collide(%q, %q) = %v, want %v", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+// Each tenant reads and writes its own indices, created on first use with the mapping of the
+// unprefixed one.
+func TestStoreIsolatesTenants(t *testing.T) {
+	backend, err := embedded.Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := New(backend, map[string]string{"requests": "logs"})
+	if err := store.EnsureIndex(context.Background(), "requests", storage.Mapping{"page": storage.FieldKeyword}); err != nil {
+		t.Fatal(err)
+	}
+
+	acme := WithTenant(context.Background(), "acme")
+	res, err := store.Index(acme, "requests", map[string]string{"page": "/a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.Get(acme, "requests", res.ID); err != nil {
+		t.Errorf("tenant cannot read its document: %v", err)
+	}
+	if _, err := store.Get(WithTenant(context.Background(), "globex"), "requests", res.ID); err != storage.ErrNotFound {
+		t.Errorf("another tenant reads the document: err = %v", err)
+	}
+	if _, err := store.Get(context.Background(), "requests", res.ID); err != storage.ErrNotFound {
+		t.Errorf("the default tenant reads the document: err = %v", err)
+	}
+	if _, err := backend.Get(context.Background(), "acme-logs", res.ID); err != nil {
+		t.Errorf("document not in acme-logs: %v", err)
+	}
+}
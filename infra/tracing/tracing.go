@@ -0,0 +1,154 @@
+// Package tracing exports the spans of the server with OpenTelemetry and carries W3C trace context
+// in and out of it.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type Config struct {
+	// Exporter is none, otlp, stdout or file.
+	Exporter string
+	// Endpoint is the URL of the OTLP/HTTP collector, like http://localhost:4318.
+	Endpoint string
+	// File receives the spans of the file exporter, one JSON object per span.
+	File        string
+	ServiceName string
+	// SampleRatio is the share of new traces that are recorded. Traces started by a caller follow
+	// its decision.
+	SampleRatio float64
+}
+
+// Init installs the tracer provider and the W3C propagators. Without an exporter spans are not
+// recorded, but incoming trace context is still passed on to the backends. shutdown flushes the
+// spans not exported yet.
+func Init(ctx context.Context, config Config) (shutdown func(context.Context) error, err error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	var exporter sdktrace.SpanExporter
+	var file io.Closer
+	switch config.Exporter {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "otlp":
+		exporter, err = otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(config.Endpoint))
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case "file":
+		var f *os.File
+		f, err = os.OpenFile(config.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err == nil {
+			file = f
+			exporter, err = stdouttrace.New(stdouttrace.WithWriter(f))
+		}
+	default:
+		return nil, fmt.Errorf("tracing: unknown exporter %q", config.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("tracing: %w", err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		attribute.String("service.name", config.ServiceName),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("tracing: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if file != nil {
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		return err
+	}, nil
+}
+
+// Handler starts a span for every request, continuing the trace of the traceparent header.
+func Handler(next http.Handler) http.Handler {
+	return otelhttp.NewHandler(next, "http.server", otelhttp.WithSpanNameFormatter(
+		func(_ string, r *http.Request) string {
+			return "HTTP " + r.Method
+		},
+	))
+}
+
+// Route names the span of a request after its mux route, once the router has matched it, so ids
+// in paths do not end up in span names.
+func Route(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				span := trace.SpanFromContext(r.Context())
+				span.SetName(r.Method + " " + template)
+				span.SetAttributes(attribute.String("http.route", template))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Transport adds the trace context of each request to its headers before base sends it.
+func Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return roundTripper(func(r *http.Request) (*http.Response, error) {
+		r = r.Clone(r.Context())
+		otel.GetTextMapPropagator().Inject(r.Context(), propagation.HeaderCarrier(r.Header))
+		return base.RoundTrip(r)
+	})
+}
+
+type roundTripper func(*http.Request) (*http.Response, error)
+
+func (f roundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// StartClient starts the span of a call to a storage backend, named after the call.
+func StartClient(ctx context.Context, tracer trace.Tracer, system, call, index string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, call,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", system),
+			attribute.String("db.operation", call),
+			attribute.String("db.name", index),
+		),
+	)
+}
+
+// Fail marks the span of ctx as failed with err.
+func Fail(ctx context.Context, err error) {
+	span := trace.SpanFromContext(ctx)
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
@@ -1,27 +1,70 @@
 package zincsearch
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
-	zinc "github.com/zinclabs/sdk-go-zincsearch"
+	"log/slog"
 	"net/http"
-	"os"
+	"sofa-logs-servers/infra/logging"
+	"sofa-logs-servers/infra/storage"
+	"sofa-logs-servers/infra/tracing"
+	"time"
+
+	"github.com/google/uuid"
+	jsoniter "github.com/json-iterator/go"
+	zinc "github.com/zinclabs/sdk-go-zincsearch"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var _ storage.Storage = ZincClient{}
+
+var tracer = otel.Tracer("sofa-logs-servers/infra/zincsearch")
+
 type ZincClient struct {
 	Ctx    context.Context
 	Client *zinc.APIClient
+	// Logger is where fail reports calls that carry no request logger, like the index checks
+	// at startup.
+	Logger *slog.Logger
+}
+
+type searchResponse struct {
+	Aggregations map[string]json.RawMessage `json:"aggregations"`
+	Hits         struct {
+		Total struct {
+			Value int `json:"value"`
+		} `json:"total"`
+		Hits []struct {
+			Index     string              `json:"_index"`
+			Id        string              `json:"_id"`
+			Score     float64             `json:"_score"`
+			Timestamp time.Time           `json:"@timestamp"`
+			Source    jsoniter.RawMessage `json:"_source"`
+		} `json:"hits"`
+	} `json:"hits"`
+}
+
+type bulkResponse struct {
+	Items []map[string]struct {
+		Status int         `json:"status"`
+		Error  interface{} `json:"error"`
+	} `json:"items"`
 }
 
 // NewClient creates a new client to the variable Client.
-func NewClient(url, username, password string) (ZincClient, error) {
+func NewClient(url, username, password string, logger *slog.Logger) (ZincClient, error) {
 	ctx := context.WithValue(context.Background(), zinc.ContextBasicAuth, zinc.BasicAuth{
 		UserName: username,
 		Password: password,
 	})
 
 	configuration := zinc.NewConfiguration()
+	configuration.HTTPClient = &http.Client{Transport: tracing.Transport(nil)}
 	configuration.Servers = zinc.ServerConfigurations{
 		zinc.ServerConfiguration{
 			URL: url,
@@ -31,54 +74,499 @@ func NewClient(url, username, password string) (ZincClient, error) {
 	return ZincClient{
 		Ctx:    ctx,
 		Client: zinc.NewAPIClient(configuration),
+		Logger: logger,
 	}, nil
 
 }
 
-func Init() (ZincClient, error) {
+// zincMapping translates a storage mapping to the properties format of the Zinc index API.
+func zincMapping(mapping storage.Mapping) map[string]interface{} {
+	properties := map[string]interface{}{}
+	for field, fieldType := range mapping {
+		zincType := string(fieldType)
+		if fieldType == storage.FieldLong || fieldType == storage.FieldDouble {
+			zincType = "numeric"
+		}
 
-	zincClient, err := NewClient(
-		os.Getenv("ELASTICSEARCH_URL"),
-		os.Getenv("ELASTICSEARCH_USERNAME"),
-		os.Getenv("ELASTICSEARCH_PASSWORD"),
-	)
-	if err != nil {
-		return ZincClient{}, err
+		properties[field] = map[string]interface{}{
+			"type":         zincType,
+			"index":        true,
+			"sortable":     true,
+			"aggregatable": fieldType != storage.FieldText,
+		}
+	}
+	return map[string]interface{}{"properties": properties}
+}
+
+// start starts the span of call, a call of the Zinc SDK on index.
+func start(ctx context.Context, call, index string) (context.Context, trace.Span) {
+	return tracing.StartClient(ctx, tracer, "zincsearch", call, index)
+}
+
+// fail logs a call to Zinc that failed with res, which may be nil, and returns err related to the
+// storage errors.
+func (z ZincClient) fail(ctx context.Context, operation, index string, res *http.Response, err error) error {
+	status := 0
+	if res != nil {
+		status = res.StatusCode
+	}
+	logger := z.Logger
+	if logger == nil {
+		logger = slog.Default()
 	}
+	tracing.Fail(ctx, err)
+	logging.FromContextOr(ctx, logger).Error("zincsearch call failed",
+		"operation", operation, "index", index, "status_code", status, "err", err)
+	return storage.BackendError(status, err)
+}
 
-	err = CreateIndexIfNotExist("requests", zincClient)
+func CreateIndexIfNotExist(index string, mapping storage.Mapping, zincClient ZincClient) error {
+	exists, err := zincClient.IndexExists(zincClient.Ctx, index)
 	if err != nil {
-		return ZincClient{}, err
+		return err
 	}
 
-	err = CreateIndexIfNotExist("transactions", zincClient)
+	if !exists {
+		indexMeta := *zinc.NewMetaIndexSimple() // MetaIndexSimple | Index data
+		indexMeta.SetName(index)
+		indexMeta.SetMappings(zincMapping(mapping))
+		ctx, span := start(zincClient.Ctx, "Index.Create", index)
+		defer span.End()
+		resp, r, err := zincClient.Client.Index.Create(ctx).Data(indexMeta).Execute()
+
+		if err != nil {
+			return zincClient.fail(ctx, "create_index", index, r, err)
+		}
+		if r.StatusCode != 200 {
+			return zincClient.fail(ctx, "create_index", index, r, errors.New(*resp.Message))
+		}
+	}
+	return nil
+}
+
+func (z ZincClient) IndexExists(ctx context.Context, index string) (bool, error) {
+	ctx, span := start(ctx, "Index.Exists", index)
+	defer span.End()
+
+	_, r, err := z.Client.Index.Exists(z.withAuth(ctx), index).Execute()
+	if r != nil && r.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
 	if err != nil {
-		return ZincClient{}, err
+		return false, z.fail(ctx, "index_exists", index, r, err)
 	}
+	return true, nil
+}
 
-	return zincClient, nil
+// withAuth carries the basic auth credentials of the client over to the request context.
+func (z ZincClient) withAuth(ctx context.Context) context.Context {
+	return context.WithValue(ctx, zinc.ContextBasicAuth, z.Ctx.Value(zinc.ContextBasicAuth))
 }
 
-func CreateIndexIfNotExist(index string, zincClient ZincClient) error {
-	_, r, err := zincClient.Client.Index.Exists(zincClient.Ctx, index).Execute()
+func (z ZincClient) Index(ctx context.Context, index string, document interface{}) (storage.IndexResult, error) {
+	doc, err := storage.ToMap(document)
+	if err != nil {
+		return storage.IndexResult{}, err
+	}
+
+	ctx, span := start(ctx, "Document.Index", index)
+	defer span.End()
 
-	if err != nil && r.StatusCode != http.StatusNotFound {
-		fmt.Println("err checking Index Exists", r)
+	resp, r, err := z.Client.Document.Index(z.withAuth(ctx), index).Document(doc).Execute()
+	if err != nil {
+		return storage.IndexResult{}, z.fail(ctx, "index", index, r, err)
+	}
+
+	return storage.IndexResult{ID: resp.GetId(), Result: storage.ResultCreated}, nil
+}
+
+func (z ZincClient) Update(ctx context.Context, index, id string, document interface{}) error {
+	doc, err := storage.ToMap(document)
+	if err != nil {
 		return err
 	}
 
-	if r.StatusCode == http.StatusNotFound {
-		indexMeta := *zinc.NewMetaIndexSimple() // MetaIndexSimple | Index data
-		indexMeta.SetName(index)
-		resp, r, err := zincClient.Client.Index.Create(zincClient.Ctx).Data(indexMeta).Execute()
+	ctx, span := start(ctx, "Document.Update", index)
+	defer span.End()
+
+	_, r, err := z.Client.Document.Update(z.withAuth(ctx), index, id).Document(doc).Execute()
+	if err != nil {
+		return z.fail(ctx, "update", index, r, err)
+	}
+	return nil
+}
+
+func (z ZincClient) Delete(ctx context.Context, index, id string) error {
+	ctx, span := start(ctx, "Document.Delete", index)
+	defer span.End()
+
+	_, r, err := z.Client.Document.Delete(z.withAuth(ctx), index, id).Execute()
+	if err != nil && r != nil && r.StatusCode == http.StatusNotFound {
+		return storage.ErrNotFound
+	}
+	if err != nil {
+		return z.fail(ctx, "delete", index, r, err)
+	}
+	return nil
+}
+
+func (z ZincClient) Get(ctx context.Context, index, id string) (storage.Hit, error) {
+	query := *zinc.NewMetaZincQuery() // V1ZincQuery | Query
+	metaQuery := *zinc.NewMetaTermQuery()
+	metaQuery.SetValue(id)
+	subQuery := *zinc.NewMetaQuery()
+	subQuery.SetTerm(map[string]zinc.MetaTermQuery{
+		"_id": metaQuery,
+	})
+	query.SetQuery(subQuery)
+
+	res, _, err := z.search(ctx, index, query)
+	if err != nil {
+		return storage.Hit{}, err
+	}
+
+	if len(res.Hits) == 0 {
+		return storage.Hit{}, storage.ErrNotFound
+	}
+	return res.Hits[0], nil
+}
+
+func (z ZincClient) Search(ctx context.Context, index string, q storage.Query) (storage.SearchResult, error) {
+	query := *zinc.NewMetaZincQuery() // V1ZincQuery | Query
+	query.SetQuery(zincFilter(q.Filter))
+
+	if len(q.Sort) > 0 {
+		sort := make([]string, 0, len(q.Sort)+1)
+		for _, s := range q.Sort {
+			if s.Desc {
+				sort = append(sort, "-"+s.Field)
+			} else {
+				sort = append(sort, "+"+s.Field)
+			}
+		}
+		// Pages are offsets, so documents with equal sort values need a fixed order not to be
+		// skipped or repeated between pages.
+		query.SetSort(append(sort, "+_id"))
+	}
+
+	if q.Size > 0 {
+		query.SetSize(int32(q.Size))
+	} else if len(q.Aggs) > 0 {
+		query.SetSize(0)
+	}
 
+	if len(q.Aggs) > 0 {
+		aggs, err := zincAggs(q.Aggs)
 		if err != nil {
-			fmt.Println("err Creating new Index", r)
-			return err
+			return storage.SearchResult{}, err
 		}
-		if r.StatusCode != 200 {
-			return errors.New(*resp.Message)
+		query.SetAggs(aggs)
+	}
+
+	from, err := offset(q.After)
+	if err != nil {
+		return storage.SearchResult{}, err
+	}
+	if from > 0 {
+		query.SetFrom(int32(from))
+	}
+
+	result, aggregations, err := z.search(ctx, index, query)
+	if err != nil {
+		return storage.SearchResult{}, err
+	}
+
+	for i := range result.Hits {
+		result.Hits[i].Sort = []interface{}{from + i + 1}
+	}
+
+	if len(q.Aggs) > 0 {
+		result.Aggregations, err = storage.DecodeAggregations(q.Aggs, aggregations)
+		if err != nil {
+			return storage.SearchResult{}, err
 		}
 	}
-	return nil
+	return result, nil
+}
+
+func zincAggs(aggs map[string]storage.Aggregation) (map[string]zinc.MetaAggregations, error) {
+	translated := make(map[string]zinc.MetaAggregations, len(aggs))
+	for name, agg := range aggs {
+		zincAgg := *zinc.NewMetaAggregations()
+		metric := *zinc.NewMetaAggregationMetric()
+		metric.SetField(agg.Field)
+
+		switch agg.Type {
+		case storage.AggTerms:
+			terms := *zinc.NewMetaAggregationsTerms()
+			terms.SetField(agg.Field)
+			if agg.Size > 0 {
+				terms.SetSize(int32(agg.Size))
+			}
+			zincAgg.SetTerms(terms)
+		case storage.AggCardinality:
+			zincAgg.SetCardinality(metric)
+		case storage.AggAvg:
+			zincAgg.SetAvg(metric)
+		case storage.AggSum:
+			zincAgg.SetSum(metric)
+		case storage.AggMin:
+			zincAgg.SetMin(metric)
+		case storage.AggMax:
+			zincAgg.SetMax(metric)
+		case storage.AggRange:
+			ranges := make([]zinc.MetaRange, 0, len(agg.Ranges))
+			for _, r := range agg.Ranges {
+				zincRange := *zinc.NewMetaRange()
+				if r.From != nil {
+					zincRange.SetFrom(float32(*r.From))
+				}
+				if r.To != nil {
+					zincRange.SetTo(float32(*r.To))
+				}
+				ranges = append(ranges, zincRange)
+			}
+			rangeAgg := *zinc.NewMetaAggregationRange()
+			rangeAgg.SetField(agg.Field)
+			rangeAgg.SetRanges(ranges)
+			zincAgg.SetRange(rangeAgg)
+		case storage.AggDateHistogram:
+			histogram := *zinc.NewMetaAggregationDateHistogram()
+			histogram.SetField(agg.Field)
+			histogram.SetCalendarInterval(string(agg.Interval))
+			if agg.TimeZone != "" {
+				histogram.SetTimeZone(agg.TimeZone)
+			}
+			zincAgg.SetDateHistogram(histogram)
+		default:
+			return nil, fmt.Errorf("zincsearch: %s aggregation: %w", agg.Type, storage.ErrUnsupported)
+		}
+
+		if len(agg.Aggs) > 0 {
+			sub, err := zincAggs(agg.Aggs)
+			if err != nil {
+				return nil, err
+			}
+			zincAgg.SetAggs(sub)
+		}
+		translated[name] = zincAgg
+	}
+	return translated, nil
+}
+
+// offset reads the position a search continues from. Zinc has no search_after, so the sort values
+// handed out with each hit are simply its position in the result set.
+func offset(after []interface{}) (int, error) {
+	if len(after) == 0 {
+		return 0, nil
+	}
+
+	switch value := after[0].(type) {
+	case int:
+		return value, nil
+	case float64:
+		return int(value), nil
+	}
+	return 0, fmt.Errorf("zincsearch: invalid search position %v", after[0])
+}
+
+func zincFilter(filter *storage.Filter) zinc.MetaQuery {
+	var clauses []zinc.MetaQuery
+	if filter != nil {
+		for i := range filter.And {
+			clauses = append(clauses, zincFilter(&filter.And[i]))
+		}
+
+		if len(filter.Or) > 0 {
+			should := make([]zinc.MetaQuery, 0, len(filter.Or))
+			for i := range filter.Or {
+				should = append(should, zincFilter(&filter.Or[i]))
+			}
+			boolQuery := *zinc.NewMetaBoolQuery()
+			boolQuery.SetShould(should)
+			boolQuery.SetMinimumShouldMatch(1)
+			query := *zinc.NewMetaQuery()
+			query.SetBool(boolQuery)
+			clauses = append(clauses, query)
+		}
+
+		if filter.Term != nil {
+			clauses = append(clauses, zincTerm(filter.Term))
+		}
+
+		if filter.Prefix != nil {
+			prefix := *zinc.NewMetaPrefixQuery()
+			prefix.SetValue(filter.Prefix.Value)
+			query := *zinc.NewMetaQuery()
+			query.SetPrefix(map[string]zinc.MetaPrefixQuery{filter.Prefix.Field: prefix})
+			clauses = append(clauses, query)
+		}
+
+		if filter.Wildcard != nil {
+			wildcard := *zinc.NewMetaWildcardQuery()
+			wildcard.SetValue(filter.Wildcard.Value)
+			query := *zinc.NewMetaQuery()
+			query.SetWildcard(map[string]zinc.MetaWildcardQuery{filter.Wildcard.Field: wildcard})
+			clauses = append(clauses, query)
+		}
+
+		if filter.Range != nil {
+			clauses = append(clauses, zincRange(filter.Range))
+		}
+	}
+
+	switch len(clauses) {
+	case 0:
+		query := *zinc.NewMetaQuery()
+		query.SetMatchAll(map[string]interface{}{})
+		return query
+	case 1:
+		return clauses[0]
+	}
+
+	boolQuery := *zinc.NewMetaBoolQuery()
+	boolQuery.SetMust(clauses)
+	query := *zinc.NewMetaQuery()
+	query.SetBool(boolQuery)
+	return query
+}
+
+// zincTerm matches a term exactly. Zinc only matches numeric fields through ranges, so numbers
+// become a range that holds the single value.
+func zincTerm(term *storage.Term) zinc.MetaQuery {
+	switch term.Value.(type) {
+	case int, int32, int64, uint, uint32, uint64, float32, float64:
+		return zincRange(&storage.Range{Field: term.Field, Gte: term.Value, Lte: term.Value})
+	}
+
+	termQuery := *zinc.NewMetaTermQuery()
+	termQuery.SetValue(zincValue(term.Value))
+	query := *zinc.NewMetaQuery()
+	query.SetTerm(map[string]zinc.MetaTermQuery{term.Field: termQuery})
+	return query
+}
+
+func zincRange(r *storage.Range) zinc.MetaQuery {
+	rangeQuery := *zinc.NewMetaRangeQuery()
+	if r.Gt != nil {
+		rangeQuery.SetGt(zincValue(r.Gt))
+	}
+	if r.Gte != nil {
+		rangeQuery.SetGte(zincValue(r.Gte))
+	}
+	if r.Lt != nil {
+		rangeQuery.SetLt(zincValue(r.Lt))
+	}
+	if r.Lte != nil {
+		rangeQuery.SetLte(zincValue(r.Lte))
+	}
+
+	query := *zinc.NewMetaQuery()
+	query.SetRange(map[string]zinc.MetaRangeQuery{r.Field: rangeQuery})
+	return query
+}
+
+func zincValue(value interface{}) string {
+	if t, ok := value.(time.Time); ok {
+		return t.Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(value)
+}
+
+func (z ZincClient) search(ctx context.Context, index string, query zinc.MetaZincQuery) (storage.SearchResult, map[string]json.RawMessage, error) {
+	ctx, span := start(ctx, "Search.Search", index)
+	defer span.End()
+
+	_, res, err := z.Client.Search.Search(z.withAuth(ctx), index).Query(query).Execute()
+	// The SDK models aggregation buckets as an object while Zinc answers with a list, so a
+	// successful response can still fail to decode there. The body is decoded again below.
+	if err != nil && (res == nil || res.StatusCode >= 300) {
+		return storage.SearchResult{}, nil, z.fail(ctx, "search", index, res, err)
+	}
+
+	defer func() {
+		err := res.Body.Close()
+		if err != nil {
+			panic(err)
+		}
+	}()
+
+	resDecoded := searchResponse{}
+	if err := jsoniter.NewDecoder(res.Body).Decode(&resDecoded); err != nil {
+		return storage.SearchResult{}, nil, err
+	}
+
+	result := storage.SearchResult{
+		Total: resDecoded.Hits.Total.Value,
+		Hits:  make([]storage.Hit, 0, len(resDecoded.Hits.Hits)),
+	}
+	for _, hit := range resDecoded.Hits.Hits {
+		result.Hits = append(result.Hits, storage.Hit{
+			Index:     hit.Index,
+			ID:        hit.Id,
+			Score:     hit.Score,
+			Timestamp: hit.Timestamp,
+			Source:    []byte(hit.Source),
+		})
+	}
+	return result, resDecoded.Aggregations, nil
+}
+
+func (z ZincClient) EnsureIndex(ctx context.Context, index string, mapping storage.Mapping) error {
+	return CreateIndexIfNotExist(index, mapping, ZincClient{Ctx: z.withAuth(ctx), Client: z.Client, Logger: z.Logger})
+}
+
+// Bulk sends documents through the Elasticsearch compatible bulk API. Ids are assigned here so
+// each item can be reported even when Zinc does not echo them back.
+func (z ZincClient) Bulk(ctx context.Context, index string, documents []storage.Document) ([]storage.BulkItem, error) {
+	if len(documents) == 0 {
+		return nil, nil
+	}
+
+	items := make([]storage.BulkItem, len(documents))
+	body := bytes.Buffer{}
+	encoder := jsoniter.NewEncoder(&body)
+	for i, document := range documents {
+		id := document.ID
+		if id == "" {
+			id = uuid.NewString()
+		}
+		items[i] = storage.BulkItem{ID: id, Result: storage.ResultCreated}
+
+		action := map[string]interface{}{"index": map[string]string{"_index": index, "_id": id}}
+		if err := encoder.Encode(action); err != nil {
+			return nil, err
+		}
+		if err := encoder.Encode(document.Source); err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, span := start(ctx, "Document.ESBulk", index)
+	defer span.End()
+	span.SetAttributes(attribute.Int("db.documents", len(documents)))
+
+	_, res, err := z.Client.Document.ESBulk(z.withAuth(ctx)).Query(body.String()).Execute()
+	if err != nil {
+		return nil, z.fail(ctx, "bulk", index, res, err)
+	}
+
+	resDecoded := bulkResponse{}
+	if err := jsoniter.NewDecoder(res.Body).Decode(&resDecoded); err != nil {
+		return nil, z.fail(ctx, "bulk", index, res, err)
+	}
+
+	if len(resDecoded.Items) != len(items) {
+		err := fmt.Errorf("zincsearch: bulk answered %d items for %d documents", len(resDecoded.Items), len(items))
+		return nil, z.fail(ctx, "bulk", index, res, err)
+	}
+
+	for i, item := range resDecoded.Items {
+		for _, outcome := range item {
+			if outcome.Error != nil || outcome.Status >= 300 {
+				items[i] = storage.BulkItem{Err: fmt.Errorf("zincsearch: status %d: %v", outcome.Status, outcome.Error)}
+			}
+		}
+	}
+	return items, nil
 }
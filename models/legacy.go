@@ -0,0 +1,54 @@
+package models
+
+import "time"
+
+// legacyFields maps the field names documents were stored with before the models had JSON names,
+// like "StartedAt", to the names of LogMapping and TransactionMapping.
+var legacyFields = map[string]string{
+	"UserID":    "user_id",
+	"Page":      "page",
+	"StartedAt": "started_at",
+	"EndedAt":   "ended_at",
+	"Amount":    "amount",
+	"Date":      "date",
+	"CreatedAt": "created_at",
+}
+
+// FromLegacy renames the legacy fields of source and fills in duration_ms for logs. It reports
+// false when source has no legacy field and needs no change. A field present under both names
+// keeps the current one.
+func FromLegacy(source map[string]interface{}) (map[string]interface{}, bool) {
+	migrated := make(map[string]interface{}, len(source))
+	changed := false
+	for field, value := range source {
+		if name, ok := legacyFields[field]; ok {
+			changed = true
+			if _, current := source[name]; current {
+				continue
+			}
+			field = name
+		}
+		migrated[field] = value
+	}
+	if !changed {
+		return source, false
+	}
+
+	if _, ok := migrated["duration_ms"]; !ok {
+		startedAt, startOK := parseTime(migrated["started_at"])
+		endedAt, endOK := parseTime(migrated["ended_at"])
+		if startOK && endOK {
+			migrated["duration_ms"] = endedAt.Sub(startedAt).Milliseconds()
+		}
+	}
+	return migrated, true
+}
+
+func parseTime(value interface{}) (time.Time, bool) {
+	s, ok := value.(string)
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	return t, err == nil
+}
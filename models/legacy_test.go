@@ -0,0 +1,43 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFromLegacy(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  map[string]interface{}
+		want    map[string]interface{}
+		changed bool
+	}{
+		{
+			name:   "log",
+			source: map[string]interface{}{"UserID": 7.0, "Page": "/", "StartedAt": "2024-01-01T10:00:00Z", "EndedAt": "2024-01-01T10:00:30Z", "@timestamp": "x"},
+			want: map[string]interface{}{"user_id": 7.0, "page": "/", "started_at": "2024-01-01T10:00:00Z", "ended_at": "2024-01-01T10:00:30Z",
+				"duration_ms": int64(30000), "@timestamp": "x"},
+			changed: true,
+		},
+		{
+			name:    "transaction updated once",
+			source:  map[string]interface{}{"Amount": 5.0, "Date": "2024-01-01T00:00:00Z", "CreatedAt": "old", "created_at": "new"},
+			want:    map[string]interface{}{"amount": 5.0, "date": "2024-01-01T00:00:00Z", "created_at": "new"},
+			changed: true,
+		},
+		{
+			name:   "current",
+			source: map[string]interface{}{"amount": 5.0},
+			want:   map[string]interface{}{"amount": 5.0},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, changed := FromLegacy(test.source)
+			if changed != test.changed || !reflect.DeepEqual(got, test.want) {
+				t.Errorf("FromLegacy = %v, %t, want %v, %t", got, changed, test.want, test.changed)
+			}
+		})
+	}
+}
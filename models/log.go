@@ -7,4 +7,6 @@ type Log struct {
 	Page      string    `json:"page"`
 	StartedAt time.Time `json:"started_at"`
 	EndedAt   time.Time `json:"ended_at"`
+	// DurationMs is the time spent on the page, stored so visits can be filtered by it.
+	DurationMs int64 `json:"duration_ms"`
 }
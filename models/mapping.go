@@ -0,0 +1,17 @@
+package models
+
+import "sofa-logs-servers/infra/storage"
+
+var LogMapping = storage.Mapping{
+	"user_id":     storage.FieldLong,
+	"page":        storage.FieldKeyword,
+	"started_at":  storage.FieldDate,
+	"ended_at":    storage.FieldDate,
+	"duration_ms": storage.FieldLong,
+}
+
+var TransactionMapping = storage.Mapping{
+	"amount":     storage.FieldLong,
+	"date":       storage.FieldDate,
+	"created_at": storage.FieldDate,
+}
@@ -0,0 +1,25 @@
+package health
+
+import (
+	"net/http"
+	"sofa-logs-servers/infra/health"
+	"sofa-logs-servers/utils"
+)
+
+// Live answers as long as the process serves requests.
+func Live(w http.ResponseWriter, r *http.Request) {
+	utils.WriteJson(w, map[string]string{"status": health.StatusOK})
+}
+
+// Ready answers 503 with the failing checks until every dependency is reachable.
+func Ready(checker *health.Checker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := checker.Check(r.Context())
+
+		status := http.StatusOK
+		if report.Status != health.StatusOK {
+			status = http.StatusServiceUnavailable
+		}
+		utils.WriteJsonStatus(w, report, status)
+	}
+}
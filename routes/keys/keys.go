@@ -0,0 +1,139 @@
+package keys
+
+import (
+	"errors"
+	"net/http"
+	"sofa-logs-servers/infra/auth"
+	"sofa-logs-servers/infra/logging"
+	"sofa-logs-servers/infra/storage"
+	"sofa-logs-servers/infra/tenant"
+	"sofa-logs-servers/utils"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+type CreateForm struct {
+	Name   string       `json:"name"`
+	Scopes []auth.Scope `json:"scopes"`
+	Tenant string       `json:"tenant"`
+}
+
+type RevokeForm struct {
+	KeyID string `json:"key_id"`
+}
+
+// CreateRes is the only response that carries the raw key, it cannot be read again later.
+type CreateRes struct {
+	ID        string       `json:"id"`
+	Key       string       `json:"key"`
+	Name      string       `json:"name"`
+	Scopes    []auth.Scope `json:"scopes"`
+	Tenant    string       `json:"tenant,omitempty"`
+	CreatedAt time.Time    `json:"created_at"`
+}
+
+func (form CreateForm) validate(policy auth.Policy) error {
+	rules := []utils.Rule{
+		utils.Required("name", form.Name),
+		utils.Check(len(form.Scopes) > 0, "scopes", "scopes IS REQUIRED"),
+		utils.Check(form.Tenant == "" || tenant.Validate(form.Tenant) == nil, "tenant", "INVALID TENANT"),
+	}
+	for _, scope := range form.Scopes {
+		rules = append(rules, utils.Check(policy.HasRole(scope), "scopes", "UNKNOWN SCOPE "+string(scope)))
+	}
+	return utils.Validate(rules...)
+}
+
+// Create issues a key whose scopes have to be roles of policy.
+func Create(keys *auth.KeyStore, policy auth.Policy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			err := r.Body.Close()
+			if err != nil {
+				panic(err)
+			}
+		}()
+
+		form := CreateForm{}
+		err := jsoniter.NewDecoder(r.Body).Decode(&form)
+		if err != nil {
+			utils.WriteBadBody(w)
+			return
+		}
+
+		if err := form.validate(policy); err != nil {
+			utils.WriteValidationErr(w, err)
+			return
+		}
+
+		// Keys bound to a tenant only issue keys for that tenant.
+		if principal, _ := auth.FromContext(r.Context()); principal.Tenant != "" {
+			if form.Tenant != "" && form.Tenant != principal.Tenant {
+				utils.WriteErr(w, "CREDENTIALS ARE BOUND TO ANOTHER TENANT", http.StatusForbidden)
+				return
+			}
+			form.Tenant = principal.Tenant
+		}
+
+		key, raw, err := keys.Create(r.Context(), form.Name, form.Scopes, form.Tenant)
+		if err != nil {
+			utils.WriteStorageErr(w, err, "BAD RESPONSE FROM STORAGE WHILE CREATING KEY")
+			return
+		}
+
+		principal, _ := auth.FromContext(r.Context())
+		logging.FromContext(r.Context()).Info("api key created",
+			"key_id", key.ID, "scopes", key.Scopes, "tenant", key.Tenant, "by", principal.ID)
+
+		utils.WriteJsonStatus(w, CreateRes{
+			ID:        key.ID,
+			Key:       raw,
+			Name:      key.Name,
+			Scopes:    key.Scopes,
+			Tenant:    key.Tenant,
+			CreatedAt: key.CreatedAt,
+		}, http.StatusCreated)
+	}
+}
+
+func Revoke(keys *auth.KeyStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			err := r.Body.Close()
+			if err != nil {
+				panic(err)
+			}
+		}()
+
+		form := RevokeForm{}
+		err := jsoniter.NewDecoder(r.Body).Decode(&form)
+		if err != nil {
+			utils.WriteBadBody(w)
+			return
+		}
+
+		if form.KeyID == "" {
+			utils.WriteValidationErr(w, utils.Invalid("key_id", "key_id IS REQUIRED"))
+			return
+		}
+
+		principal, _ := auth.FromContext(r.Context())
+		err = keys.Revoke(r.Context(), form.KeyID, principal.Tenant)
+		if errors.Is(err, auth.ErrFileKey) {
+			utils.WriteErr(w, "KEYS FROM THE KEY FILE CANNOT BE REVOKED", http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, storage.ErrNotFound) {
+			utils.WriteErr(w, "KEY NOT FOUND", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			utils.WriteStorageErr(w, err, "BAD RESPONSE FROM STORAGE WHILE REVOKING KEY")
+			return
+		}
+
+		logging.FromContext(r.Context()).Info("api key revoked", "key_id", form.KeyID, "by", principal.ID)
+		utils.WriteJson(w, "Key Revoked")
+	}
+}
@@ -1,9 +1,13 @@
 package requests
 
 import (
+	"errors"
 	"net/http"
-	"sofa-logs-servers/infra/zincsearch"
+	"sofa-logs-servers/infra/auth"
+	"sofa-logs-servers/infra/storage"
+	"sofa-logs-servers/models"
 	"sofa-logs-servers/utils"
+	"strconv"
 	"time"
 
 	jsoniter "github.com/json-iterator/go"
@@ -42,7 +46,118 @@ type CreateRes struct {
 	PrimaryTerm int `json:"_primary_term"`
 }
 
-func Create(w http.ResponseWriter, r *http.Request, zincClient zincsearch.ZincClient) {
+// validate applies the rules every new request log must satisfy.
+func (form CreateForm) validate() error {
+	return utils.Validate(visitRules(form.Page, form.StartedAt, form.EndedAt)...)
+}
+
+// validate applies the rules of CreateForm to the new version of a request log.
+func (form UpdateForm) validate() error {
+	return utils.Validate(append(
+		[]utils.Rule{utils.Required("request_id", form.ID)},
+		visitRules(form.Page, form.StartedAt, form.EndedAt)...,
+	)...)
+}
+
+// visitRules are the rules of a visit to a page, which cannot end before it started.
+func visitRules(page string, startedAt, endedAt time.Time) []utils.Rule {
+	return []utils.Rule{
+		utils.Required("page", page),
+		utils.Date("started_at", startedAt),
+		utils.Date("ended_at", endedAt),
+		utils.NotBefore("ended_at", endedAt, "started_at", startedAt),
+	}
+}
+
+var (
+	errSubjectNotUserID = errors.New("TOKEN SUBJECT IS NOT A USER ID")
+	errSubjectMismatch  = errors.New("user_id DOES NOT MATCH TOKEN SUBJECT")
+	errNotOwner         = errors.New("LOG BELONGS TO ANOTHER USER")
+)
+
+// bindSubject ties the log to the user a token was issued to: the token subject fills in a missing
+// user_id and has to match one given in the body. Logs sent with an API key or by an admin keep
+// their user_id.
+func (form *CreateForm) bindSubject(r *http.Request) error {
+	return bindSubject(r, &form.UserID)
+}
+
+// bindSubject keeps a token from moving a log to another user, like CreateForm.bindSubject.
+func (form *UpdateForm) bindSubject(r *http.Request) error {
+	return bindSubject(r, &form.UserID)
+}
+
+func bindSubject(r *http.Request, formUserID *uint) error {
+	userID, ok, err := tokenUser(r)
+	if err != nil || !ok {
+		return err
+	}
+
+	if *formUserID != 0 && *formUserID != userID {
+		return errSubjectMismatch
+	}
+	*formUserID = userID
+	return nil
+}
+
+// tokenUser returns the user a token was issued to. ok is false for API keys and admins, which
+// reach the logs of every user.
+func tokenUser(r *http.Request) (userID uint, ok bool, err error) {
+	principal, found := auth.FromContext(r.Context())
+	if !found || principal.Subject == "" || principal.HasScope(auth.ScopeAdmin) {
+		return 0, false, nil
+	}
+
+	subject, err := strconv.ParseUint(principal.Subject, 10, 0)
+	if err != nil {
+		return 0, false, errSubjectNotUserID
+	}
+	return uint(subject), true, nil
+}
+
+// checkOwner keeps a token from changing the stored log with id when it belongs to another user.
+func checkOwner(r *http.Request, store storage.Storage, id string) error {
+	userID, ok, err := tokenUser(r)
+	if err != nil || !ok {
+		return err
+	}
+
+	hit, err := store.Get(r.Context(), "requests", id)
+	if err != nil {
+		return err
+	}
+	log := models.Log{}
+	if err := jsoniter.Unmarshal(hit.Source, &log); err != nil {
+		return err
+	}
+	if log.UserID != userID {
+		return errNotOwner
+	}
+	return nil
+}
+
+// writeSubjectErr answers the refusal of bindSubject or checkOwner with 403, and a failed read
+// of the stored log like any storage error.
+func writeSubjectErr(w http.ResponseWriter, err error) {
+	switch err {
+	case errSubjectNotUserID, errSubjectMismatch, errNotOwner:
+		utils.WriteErr(w, err.Error(), http.StatusForbidden)
+	default:
+		utils.WriteStorageErr(w, err, "BAD RESPONSE FROM STORAGE WHILE READING DOCUMENT")
+	}
+}
+
+func (form CreateForm) document() models.Log {
+	return models.Log{
+		UserID:     form.UserID,
+		Page:       form.Page,
+		StartedAt:  form.StartedAt,
+		EndedAt:    form.EndedAt,
+		DurationMs: form.EndedAt.Sub(form.StartedAt).Milliseconds(),
+	}
+}
+
+func Create(w http.ResponseWriter, r *http.Request, store storage.Storage) {
 
 	defer func() {
 		err := r.Body.Close()
@@ -54,51 +169,34 @@ func Create(w http.ResponseWriter, r *http.Request, zincClient zincsearch.ZincCl
 	form := CreateForm{}
 	err := jsoniter.NewDecoder(r.Body).Decode(&form)
 	if err != nil {
-		utils.WriteErr(w, "BAD BODY FORMAT", http.StatusBadRequest)
+		utils.WriteBadBody(w)
 		return
 	}
 
-	if form.Page == "" {
-		utils.WriteErr(w, "EMPTY Page", http.StatusBadRequest)
+	if err := form.validate(); err != nil {
+		utils.WriteValidationErr(w, err)
 		return
 	}
 
-	if form.StartedAt.IsZero() {
-		utils.WriteErr(w, "EMPTY Start Request Time", http.StatusBadRequest)
-	}
-
-	if form.EndedAt.IsZero() {
-		utils.WriteErr(w, "EMPTY End Request Time", http.StatusBadRequest)
-	}
-
-	document := map[string]interface{}{
-		"UserID":    form.UserID,
-		"Page":      form.Page,
-		"StartedAt": form.StartedAt,
-		"EndedAt":   form.EndedAt,
-	} // map[string]interface{} | Document
-
-	_, res, err := zincClient.Client.Document.Index(zincClient.Ctx, "requests").Document(document).Execute()
-
-	if err != nil {
-		utils.WriteErr(w, "Could not send Request to zinc-search Client", http.StatusBadRequest)
+	if err := form.bindSubject(r); err != nil {
+		writeSubjectErr(w, err)
 		return
 	}
 
-	if res.StatusCode != 200 {
-		utils.WriteErr(w, "BAD RESPONSE FROM ZINC WHILE CREATING DOCUMENT", http.StatusBadRequest)
+	result, err := store.Index(r.Context(), "requests", form.document())
+	if err != nil {
+		utils.WriteStorageErr(w, err, "BAD RESPONSE FROM STORAGE WHILE CREATING DOCUMENT")
 		return
 	}
 
-	respDecoded := CreateRes{}
-	err = jsoniter.NewDecoder(res.Body).Decode(&respDecoded)
-	if err != nil {
-		utils.WriteErr(w, "ERROR PARSING RESPONSE FROM ELASTIC", http.StatusInternalServerError)
+	status := http.StatusOK
+	if result.Result == storage.ResultQueued {
+		status = http.StatusAccepted
 	}
-	utils.WriteJson(w, respDecoded)
+	utils.WriteJsonStatus(w, CreateRes{Index: "requests", Id: result.ID, Result: result.Result}, status)
 }
 
-func Update(w http.ResponseWriter, r *http.Request, zincClient zincsearch.ZincClient) {
+func Update(w http.ResponseWriter, r *http.Request, store storage.Storage) {
 	defer func() {
 		err := r.Body.Close()
 		if err != nil {
@@ -110,44 +208,36 @@ func Update(w http.ResponseWriter, r *http.Request, zincClient zincsearch.ZincCl
 
 	err := jsoniter.NewDecoder(r.Body).Decode(&form)
 	if err != nil {
-		utils.WriteErr(w, "BAD BODY FORMAT", http.StatusBadRequest)
+		utils.WriteBadBody(w)
 		return
 	}
 
-	if form.ID == "" {
-		utils.WriteErr(w, "request_id IS REQUIRED", http.StatusBadRequest)
+	if err := form.validate(); err != nil {
+		utils.WriteValidationErr(w, err)
 		return
 	}
 
-	if form.Page == "" {
-		utils.WriteErr(w, "page IS REQUIRED", http.StatusBadRequest)
+	if err := checkOwner(r, store, form.ID); err != nil {
+		writeSubjectErr(w, err)
 		return
 	}
 
-	if form.StartedAt.IsZero() {
-		utils.WriteErr(w, "EMPTY Start Request Time", http.StatusBadRequest)
+	if err := form.bindSubject(r); err != nil {
+		writeSubjectErr(w, err)
+		return
 	}
 
-	if form.EndedAt.IsZero() {
-		utils.WriteErr(w, "EMPTY End Request Time", http.StatusBadRequest)
+	document := models.Log{
+		UserID:     form.UserID,
+		Page:       form.Page,
+		StartedAt:  form.StartedAt,
+		EndedAt:    form.EndedAt,
+		DurationMs: form.EndedAt.Sub(form.StartedAt).Milliseconds(),
 	}
 
-	document := map[string]interface{}{
-		"UserID":    form.UserID,
-		"Page":      form.Page,
-		"StartedAt": form.StartedAt,
-		"EndedAt":   form.EndedAt,
-	} // map[string]interface{} | Document
-
-	_, res, err := zincClient.Client.Document.Update(zincClient.Ctx, "requests", form.ID).Document(document).Execute()
-
+	err = store.Update(r.Context(), "requests", form.ID, document)
 	if err != nil {
-		utils.WriteErr(w, "Could not send Request to zinc-search Client", http.StatusBadRequest)
-		return
-	}
-
-	if res.StatusCode != 200 {
-		utils.WriteErr(w, "BAD RESPONSE FROM ZINC WHILE UPDATING DOCUMENT", http.StatusBadRequest)
+		utils.WriteStorageErr(w, err, "BAD RESPONSE FROM STORAGE WHILE UPDATING DOCUMENT")
 		return
 	}
 
@@ -155,7 +245,7 @@ func Update(w http.ResponseWriter, r *http.Request, zincClient zincsearch.ZincCl
 
 }
 
-func Delete(w http.ResponseWriter, r *http.Request, zincClient zincsearch.ZincClient) {
+func Delete(w http.ResponseWriter, r *http.Request, store storage.Storage) {
 	defer func() {
 		err := r.Body.Close()
 		if err != nil {
@@ -166,25 +256,93 @@ func Delete(w http.ResponseWriter, r *http.Request, zincClient zincsearch.ZincCl
 	form := DeleteFrom{}
 	err := jsoniter.NewDecoder(r.Body).Decode(&form)
 	if err != nil {
-		utils.WriteErr(w, "BAD BODY FORMAT", http.StatusBadRequest)
+		utils.WriteBadBody(w)
 		return
 	}
 
 	if form.ID == "" {
-		utils.WriteErr(w, "request_id IS REQUIRED", http.StatusBadRequest)
+		utils.WriteValidationErr(w, utils.Invalid("request_id", "request_id IS REQUIRED"))
 		return
 	}
 
-	_, res, err := zincClient.Client.Document.Delete(zincClient.Ctx, "requests", form.ID).Execute()
-	if err != nil {
-		utils.WriteErr(w, "Error deleting the Document", http.StatusBadRequest)
+	if err := checkOwner(r, store, form.ID); err != nil {
+		writeSubjectErr(w, err)
 		return
 	}
 
-	if res.StatusCode != 200 {
-		utils.WriteErr(w, "BAD RESPONSE FROM ZINC WHILE DELETING DOCUMENT", http.StatusBadRequest)
+	err = store.Delete(r.Context(), "requests", form.ID)
+	if err != nil {
+		utils.WriteStorageErr(w, err, "BAD RESPONSE FROM STORAGE WHILE DELETING DOCUMENT")
 		return
 	}
 
 	utils.WriteJson(w, "Document Deleted")
 }
+
+// Bulk indexes a JSON array or NDJSON stream of request logs, validating each one like Create.
+func Bulk(w http.ResponseWriter, r *http.Request, store storage.Storage) {
+	defer func() {
+		err := r.Body.Close()
+		if err != nil {
+			panic(err)
+		}
+	}()
+
+	items, err := utils.DecodeBulk(w, r)
+	if err != nil {
+		utils.WriteBulkErr(w, err)
+		return
+	}
+
+	response := utils.BulkResponse{Items: make([]utils.BulkItem, len(items))}
+	var documents []storage.Document
+	var positions []int
+
+	for i, item := range items {
+		response.Items[i].Position = i
+
+		form := CreateForm{}
+		if err := jsoniter.Unmarshal(item, &form); err != nil {
+			response.Items[i].Code, response.Items[i].Error = utils.CodeBadBody, "BAD BODY FORMAT"
+			continue
+		}
+
+		if err := form.validate(); err != nil {
+			response.Items[i].Code, response.Items[i].Error = utils.CodeValidation, err.Error()
+			continue
+		}
+
+		if err := form.bindSubject(r); err != nil {
+			response.Items[i].Code, response.Items[i].Error = utils.CodeForbidden, err.Error()
+			continue
+		}
+
+		documents = append(documents, storage.Document{Source: form.document()})
+		positions = append(positions, i)
+	}
+
+	stored, err := store.Bulk(r.Context(), "requests", documents)
+	if err != nil {
+		utils.WriteStorageErr(w, err, "BAD RESPONSE FROM STORAGE WHILE CREATING DOCUMENTS")
+		return
+	}
+
+	for j, item := range stored {
+		if item.Err != nil {
+			response.Items[positions[j]].Code = utils.CodeStorage
+			response.Items[positions[j]].Error = "COULD NOT STORE DOCUMENT"
+			continue
+		}
+		response.Items[positions[j]].ID = item.ID
+		response.Items[positions[j]].Result = item.Result
+	}
+
+	status := http.StatusOK
+	for _, item := range response.Items {
+		response.Errors = response.Errors || item.Error != ""
+		if item.Result == storage.ResultQueued {
+			status = http.StatusAccepted
+		}
+	}
+	utils.WriteJsonStatus(w, response, status)
+}
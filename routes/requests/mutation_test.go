@@ -0,0 +1,270 @@
+package requests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sofa-logs-servers/infra/auth"
+	"sofa-logs-servers/infra/storage"
+	"sofa-logs-servers/models"
+	"sofa-logs-servers/routes/routetest"
+	"sofa-logs-servers/utils"
+	"strings"
+	"testing"
+)
+
+const validLog = `{"user_id":7,"page":"/home","started_at":"2024-01-01T10:00:00Z","ended_at":"2024-01-01T10:00:30Z"}`
+
+func TestCreate(t *testing.T) {
+	store := routetest.Store(t, "requests", models.LogMapping)
+
+	res := CreateRes{}
+	routetest.Decode(t, routetest.Post(Create, store, validLog), http.StatusOK, &res)
+
+	log := models.Log{}
+	routetest.Source(t, store, "requests", res.Id, &log)
+	if log.Page != "/home" || log.UserID != 7 || log.DurationMs != 30000 {
+		t.Errorf("stored %+v", log)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	store := routetest.Store(t, "requests", models.LogMapping)
+	id := routetest.Index(t, store, "requests", models.Log{Page: "/old"})
+
+	body := `{"request_id":"` + id + `","page":"/new","started_at":"2024-01-01T10:00:00Z","ended_at":"2024-01-01T10:00:01Z"}`
+	if w := routetest.Post(Update, store, body); w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+
+	log := models.Log{}
+	routetest.Source(t, store, "requests", id, &log)
+	if log.Page != "/new" || log.DurationMs != 1000 {
+		t.Errorf("stored %+v", log)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	store := routetest.Store(t, "requests", models.LogMapping)
+	id := routetest.Index(t, store, "requests", models.Log{Page: "/home"})
+
+	if w := routetest.Post(Delete, store, `{"request_id":"`+id+`"}`); w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+	if n := routetest.Count(t, store, "requests"); n != 0 {
+		t.Errorf("%d documents left", n)
+	}
+}
+
+func TestBulk(t *testing.T) {
+	store := routetest.Store(t, "requests", models.LogMapping)
+
+	res := utils.BulkResponse{}
+	body := validLog + "\n{broken\n" + `{"page":"/"}` + "\n"
+	routetest.Decode(t, routetest.Post(Bulk, store, body), http.StatusOK, &res)
+	if !res.Errors || len(res.Items) != 3 {
+		t.Fatalf("response %+v", res)
+	}
+	if res.Items[0].ID == "" || res.Items[1].Code != utils.CodeBadBody || res.Items[2].Code != utils.CodeValidation {
+		t.Errorf("items %+v", res.Items)
+	}
+	if n := routetest.Count(t, store, "requests"); n != 1 {
+		t.Errorf("%d documents stored, want 1", n)
+	}
+}
+
+func TestBulkRejectsOversizedBodies(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		code   utils.ErrorCode
+	}{
+		{"too many items", strings.Repeat(validLog+"\n", utils.MaxBulkItems+1), http.StatusBadRequest, utils.CodeBadBody},
+		{"too many bytes", "[" + strings.Repeat(" ", utils.MaxBulkBytes) + "]", http.StatusRequestEntityTooLarge, utils.CodeTooLarge},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := routetest.Store(t, "requests", models.LogMapping)
+
+			if form := routetest.Error(t, routetest.Post(Bulk, store, test.body), test.status); form.Code != test.code {
+				t.Errorf("code = %s, want %s", form.Code, test.code)
+			}
+			if n := routetest.Count(t, store, "requests"); n != 0 {
+				t.Errorf("%d documents stored", n)
+			}
+		})
+	}
+}
+
+var (
+	user7 = auth.Principal{ID: "token:issuer/7", Subject: "7", Scopes: []auth.Scope{auth.ScopeIngest}}
+	user8 = auth.Principal{ID: "token:issuer/8", Subject: "8", Scopes: []auth.Scope{auth.ScopeIngest}}
+	admin = auth.Principal{ID: "token:issuer/9", Subject: "9", Scopes: []auth.Scope{auth.ScopeAdmin}}
+)
+
+// as runs handler on a POST of body made with the credentials of principal.
+func as(principal auth.Principal, handler routetest.Handler, store storage.Storage, body string) *httptest.ResponseRecorder {
+	r := routetest.Request(body)
+	return routetest.Serve(handler, store, r.WithContext(auth.WithPrincipal(r.Context(), principal)))
+}
+
+func TestCreateBindsTokenSubject(t *testing.T) {
+	tests := []struct {
+		name      string
+		principal auth.Principal
+		body      string
+		status    int
+		userID    uint
+	}{
+		{"own user", user7, validLog, http.StatusOK, 7},
+		{"missing user", user8, `{"page":"/","started_at":"2024-01-01T10:00:00Z","ended_at":"2024-01-01T10:00:30Z"}`, http.StatusOK, 8},
+		{"other user", user8, validLog, http.StatusForbidden, 0},
+		{"admin for a user", admin, validLog, http.StatusOK, 7},
+		{"admin without user", admin, `{"page":"/","started_at":"2024-01-01T10:00:00Z","ended_at":"2024-01-01T10:00:30Z"}`, http.StatusOK, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := routetest.Store(t, "requests", models.LogMapping)
+
+			w := as(test.principal, Create, store, test.body)
+			if w.Code != test.status {
+				t.Fatalf("status = %d, want %d, body %s", w.Code, test.status, w.Body)
+			}
+			if test.status != http.StatusOK {
+				return
+			}
+			res := CreateRes{}
+			routetest.Decode(t, w, http.StatusOK, &res)
+			log := models.Log{}
+			routetest.Source(t, store, "requests", res.Id, &log)
+			if log.UserID != test.userID {
+				t.Errorf("stored for user %d, want %d", log.UserID, test.userID)
+			}
+		})
+	}
+}
+
+// Tokens only change the logs of their own user, admins change any.
+func TestOwnership(t *testing.T) {
+	update := func(id string) string {
+		return `{"request_id":"` + id + `","user_id":7,"page":"/new","started_at":"2024-01-01T10:00:00Z","ended_at":"2024-01-01T10:00:01Z"}`
+	}
+	remove := func(id string) string { return `{"request_id":"` + id + `"}` }
+
+	tests := []struct {
+		name      string
+		principal auth.Principal
+		handler   routetest.Handler
+		body      func(id string) string
+		status    int
+	}{
+		{"owner updates", user7, Update, update, http.StatusOK},
+		{"other user updates", user8, Update, update, http.StatusForbidden},
+		{"admin updates", admin, Update, update, http.StatusOK},
+		{"owner deletes", user7, Delete, remove, http.StatusOK},
+		{"other user deletes", user8, Delete, remove, http.StatusForbidden},
+		{"admin deletes", admin, Delete, remove, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := routetest.Store(t, "requests", models.LogMapping)
+			id := routetest.Index(t, store, "requests", models.Log{UserID: 7, Page: "/old"})
+
+			w := as(test.principal, test.handler, store, test.body(id))
+			if w.Code != test.status {
+				t.Fatalf("status = %d, want %d, body %s", w.Code, test.status, w.Body)
+			}
+
+			hit, err := store.Get(context.Background(), "requests", id)
+			if test.status == http.StatusForbidden {
+				if err != nil || !strings.Contains(string(hit.Source), `"/old"`) {
+					t.Errorf("refused change went through: %s, %v", hit.Source, err)
+				}
+				return
+			}
+			if err == nil && !strings.Contains(string(hit.Source), `"user_id":7`) {
+				t.Errorf("log moved to another user: %s", hit.Source)
+			}
+		})
+	}
+}
+
+func TestErrorCodes(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler routetest.Handler
+		body    string
+		status  int
+		code    utils.ErrorCode
+	}{
+		{"update missing", Update, `{"request_id":"missing","page":"/","started_at":"2024-01-01T10:00:00Z","ended_at":"2024-01-01T10:00:01Z"}`, http.StatusNotFound, utils.CodeNotFound},
+		{"delete missing", Delete, `{"request_id":"missing"}`, http.StatusNotFound, utils.CodeNotFound},
+		{"find missing", FindById, `{"request_id":"missing"}`, http.StatusNotFound, utils.CodeNotFound},
+		{"create bad body", Create, `{"page":`, http.StatusBadRequest, utils.CodeBadBody},
+		{"update bad body", Update, `[]`, http.StatusBadRequest, utils.CodeBadBody},
+		{"delete bad body", Delete, ``, http.StatusBadRequest, utils.CodeBadBody},
+		{"find bad body", FindById, `{"request_id":1}`, http.StatusBadRequest, utils.CodeBadBody},
+		{"list bad body", FindAll, `{"limit":"ten"}`, http.StatusBadRequest, utils.CodeBadBody},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := routetest.Post(test.handler, routetest.Store(t, "requests", models.LogMapping), test.body)
+			if form := routetest.Error(t, w, test.status); form.Code != test.code {
+				t.Errorf("code = %s, want %s", form.Code, test.code)
+			}
+		})
+	}
+}
+
+func TestCreateRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		fields []string
+	}{
+		{"empty", `{}`, []string{"page", "started_at", "ended_at"}},
+		{"ended before started", `{"page":"/","started_at":"2024-01-01T10:00:30Z","ended_at":"2024-01-01T10:00:00Z"}`, []string{"ended_at"}},
+		{"before earliest date", `{"page":"/","started_at":"1990-01-01T10:00:00Z","ended_at":"2024-01-01T10:00:00Z"}`, []string{"started_at"}},
+		{"in the future", `{"page":"/","started_at":"2024-01-01T10:00:00Z","ended_at":"2999-01-01T10:00:00Z"}`, []string{"ended_at"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := routetest.Store(t, "requests", models.LogMapping)
+
+			form := routetest.Error(t, routetest.Post(Create, store, test.body), http.StatusBadRequest)
+			if form.Code != utils.CodeValidation || !reflect.DeepEqual(routetest.Fields(form), test.fields) {
+				t.Errorf("error %+v, want fields %v", form, test.fields)
+			}
+			if n := routetest.Count(t, store, "requests"); n != 0 {
+				t.Errorf("%d documents stored", n)
+			}
+		})
+	}
+}
+
+// An invalid update has to leave the document alone and answer once.
+func TestUpdateStopsOnInvalidForm(t *testing.T) {
+	store := routetest.Store(t, "requests", models.LogMapping)
+	id := routetest.Index(t, store, "requests", models.Log{Page: "/old"})
+
+	w := routetest.Post(Update, store, `{"request_id":"`+id+`","page":"/new"}`)
+	if form := routetest.Error(t, w, http.StatusBadRequest); !reflect.DeepEqual(routetest.Fields(form), []string{"started_at", "ended_at"}) {
+		t.Errorf("details = %+v", form.Details)
+	}
+	if w.Body.Len() > 0 {
+		t.Errorf("more than one response written: %s", w.Body)
+	}
+
+	log := models.Log{}
+	routetest.Source(t, store, "requests", id, &log)
+	if log.Page != "/old" {
+		t.Errorf("document changed to %+v", log)
+	}
+}
@@ -1,42 +1,155 @@
 package requests
 
 import (
-	"encoding/json"
-	"fmt"
-	jsoniter "github.com/json-iterator/go"
-	zinc "github.com/zinclabs/sdk-go-zincsearch"
+	"io"
 	"net/http"
-	"sofa-logs-servers/infra/zincsearch"
+	"sofa-logs-servers/infra/storage"
 	"sofa-logs-servers/models"
 	"sofa-logs-servers/utils"
 	"time"
+
+	jsoniter "github.com/json-iterator/go"
 )
 
 type FindForm struct {
 	RequestID string `json:"request_id"`
 }
 
-type RespFind struct {
-	Took     int         `json:"took"`
-	TimedOut bool        `json:"timed_out"`
-	Shards   interface{} `json:"_shards"`
-	Hits     struct {
-		Total struct {
-			Value    int    `json:"value"`
-			Relation string `json:"relation"`
-		} `json:"total"`
-		MaxScore float64 `json:"max_score"`
-		Hits     []struct {
-			Index     string     `json:"_index"`
-			Id        string     `json:"_id"`
-			Score     float64    `json:"_score"`
-			Timestamp time.Time  `json:"@timestamp"`
-			Source    models.Log `json:"_source"`
+var sortable = []string{"user_id", "page", "started_at", "ended_at", "duration_ms"}
+
+type FindAllForm struct {
+	utils.PageForm
+	Filter *LogFilter `json:"filter"`
+}
+
+// LogFilter selects page visits. Every condition set on a filter must hold, And and Or combine
+// nested filters. Time bounds are inclusive and durations use Go syntax such as "30s" or "1m30s".
+type LogFilter struct {
+	And []LogFilter `json:"and"`
+	Or  []LogFilter `json:"or"`
+
+	UserID       *uint  `json:"user_id"`
+	Page         string `json:"page"`
+	PagePrefix   string `json:"page_prefix"`
+	PageWildcard string `json:"page_wildcard"`
+
+	StartedFrom *time.Time `json:"started_from"`
+	StartedTo   *time.Time `json:"started_to"`
+	EndedFrom   *time.Time `json:"ended_from"`
+	EndedTo     *time.Time `json:"ended_to"`
+
+	MinDuration string `json:"min_duration"`
+	MaxDuration string `json:"max_duration"`
+}
+
+type Hit struct {
+	Index     string     `json:"_index"`
+	Id        string     `json:"_id"`
+	Score     float64    `json:"_score"`
+	Timestamp time.Time  `json:"@timestamp"`
+	Source    models.Log `json:"_source"`
+}
+
+// toStorage translates the filter into the backend independent query filter.
+func (f LogFilter) toStorage() (storage.Filter, error) {
+	filter := storage.Filter{}
+
+	for _, sub := range f.And {
+		translated, err := sub.toStorage()
+		if err != nil {
+			return storage.Filter{}, err
+		}
+		filter.And = append(filter.And, translated)
+	}
+
+	for _, sub := range f.Or {
+		translated, err := sub.toStorage()
+		if err != nil {
+			return storage.Filter{}, err
+		}
+		filter.Or = append(filter.Or, translated)
+	}
+
+	if f.UserID != nil {
+		filter.And = append(filter.And, storage.Filter{Term: &storage.Term{Field: "user_id", Value: *f.UserID}})
+	}
+
+	if f.Page != "" {
+		filter.And = append(filter.And, storage.Filter{Term: &storage.Term{Field: "page", Value: f.Page}})
+	}
+
+	if f.PagePrefix != "" {
+		filter.And = append(filter.And, storage.Filter{Prefix: &storage.Pattern{Field: "page", Value: f.PagePrefix}})
+	}
+
+	if f.PageWildcard != "" {
+		filter.And = append(filter.And, storage.Filter{Wildcard: &storage.Pattern{Field: "page", Value: f.PageWildcard}})
+	}
+
+	if f.StartedFrom != nil || f.StartedTo != nil {
+		filter.And = append(filter.And, storage.Filter{Range: timeRange("started_at", f.StartedFrom, f.StartedTo)})
+	}
+
+	if f.EndedFrom != nil || f.EndedTo != nil {
+		filter.And = append(filter.And, storage.Filter{Range: timeRange("ended_at", f.EndedFrom, f.EndedTo)})
+	}
+
+	if f.MinDuration != "" || f.MaxDuration != "" {
+		durations := &storage.Range{Field: "duration_ms"}
+
+		if f.MinDuration != "" {
+			shortest, err := time.ParseDuration(f.MinDuration)
+			if err != nil {
+				return storage.Filter{}, utils.Invalid("filter.min_duration", "INVALID min_duration")
+			}
+			durations.Gte = shortest.Milliseconds()
+		}
+
+		if f.MaxDuration != "" {
+			longest, err := time.ParseDuration(f.MaxDuration)
+			if err != nil {
+				return storage.Filter{}, utils.Invalid("filter.max_duration", "INVALID max_duration")
+			}
+			durations.Lte = longest.Milliseconds()
 		}
+
+		filter.And = append(filter.And, storage.Filter{Range: durations})
 	}
+
+	return filter, nil
+}
+
+func timeRange(field string, from, to *time.Time) *storage.Range {
+	times := &storage.Range{Field: field}
+	if from != nil {
+		times.Gte = *from
+	}
+	if to != nil {
+		times.Lte = *to
+	}
+	return times
+}
+
+func toHits(storageHits []storage.Hit) ([]Hit, error) {
+	hits := make([]Hit, 0, len(storageHits))
+	for _, hit := range storageHits {
+		log := models.Log{}
+		if err := jsoniter.Unmarshal(hit.Source, &log); err != nil {
+			return nil, err
+		}
+
+		hits = append(hits, Hit{
+			Index:     hit.Index,
+			Id:        hit.ID,
+			Score:     hit.Score,
+			Timestamp: hit.Timestamp,
+			Source:    log,
+		})
+	}
+	return hits, nil
 }
 
-func FindById(w http.ResponseWriter, r *http.Request, zincClient zincsearch.ZincClient) {
+func FindById(w http.ResponseWriter, r *http.Request, store storage.Storage) {
 	defer func() {
 		err := r.Body.Close()
 		if err != nil {
@@ -47,77 +160,72 @@ func FindById(w http.ResponseWriter, r *http.Request, zincClient zincsearch.Zinc
 	form := FindForm{}
 	err := jsoniter.NewDecoder(r.Body).Decode(&form)
 	if err != nil {
-		utils.WriteErr(w, "BAD BODY FORMAT", http.StatusBadRequest)
+		utils.WriteBadBody(w)
 		return
 	}
 
 	if form.RequestID == "" {
-		utils.WriteErr(w, "REQUEST ID EMPTY !!!", http.StatusBadRequest)
+		utils.WriteValidationErr(w, utils.Invalid("request_id", "request_id IS REQUIRED"))
 		return
 	}
 
-	query := *zinc.NewMetaZincQuery() // V1ZincQuery | Query
-	metaQuery := *zinc.NewMetaTermQuery()
-	metaQuery.SetValue(form.RequestID)
-	subQuery := *zinc.NewMetaQuery()
-	subQuery.SetTerm(map[string]zinc.MetaTermQuery{
-		"_id": metaQuery,
-	})
-
-	query.SetQuery(subQuery)
+	hit, err := store.Get(r.Context(), "requests", form.RequestID)
+	if err != nil {
+		utils.WriteStorageErr(w, err, "BAD RESPONSE FROM STORAGE WHILE SEARCHING DOCUMENTS")
+		return
+	}
 
-	_, res, err := zincClient.Client.Search.Search(zincClient.Ctx, "requests").Query(query).Execute()
-	fmt.Println(res)
+	hits, err := toHits([]storage.Hit{hit})
 	if err != nil {
-		utils.WriteErr(w, "Error searching the Document", http.StatusBadRequest)
+		utils.WriteErr(w, "ERROR PARSING RESPONSE", http.StatusInternalServerError)
 		return
 	}
 
+	utils.WriteJson(w, hits)
+
+}
+
+func FindAll(w http.ResponseWriter, r *http.Request, store storage.Storage) {
 	defer func() {
-		err := res.Body.Close()
+		err := r.Body.Close()
 		if err != nil {
 			panic(err)
 		}
 	}()
 
-	resDecoded := RespFind{}
-
-	if err := json.NewDecoder(res.Body).Decode(&resDecoded); err != nil {
-		utils.WriteErr(w, "ERROR PARSING RESPONSE", http.StatusInternalServerError)
+	form := FindAllForm{}
+	err := jsoniter.NewDecoder(r.Body).Decode(&form)
+	if err != nil && err != io.EOF {
+		utils.WriteBadBody(w)
 		return
 	}
 
-	utils.WriteJson(w, resDecoded.Hits.Hits)
-
-}
-
-func FindAll(w http.ResponseWriter, _ *http.Request, zincClient zincsearch.ZincClient) {
-
-	query := *zinc.NewMetaZincQuery() // V1ZincQuery | Query
-	subQuery := *zinc.NewMetaQuery()
-	subQuery.SetMatchAll(map[string]interface {
-	}{})
-	query.SetQuery(subQuery)
-
-	_, res, err := zincClient.Client.Search.Search(zincClient.Ctx, "requests").Query(query).Execute()
-
+	query, err := utils.PageQuery(form.PageForm, sortable, storage.SortField{Field: "started_at"})
 	if err != nil {
-		utils.WriteErr(w, "Error searching the Document", http.StatusBadRequest)
+		utils.WriteValidationErr(w, err)
 		return
 	}
 
-	defer func() {
-		err := res.Body.Close()
+	if form.Filter != nil {
+		filter, err := form.Filter.toStorage()
 		if err != nil {
-			panic(err)
+			utils.WriteValidationErr(w, err)
+			return
 		}
-	}()
+		query.Filter = &filter
+	}
 
-	resDecoded := RespFind{}
-	if err := json.NewDecoder(res.Body).Decode(&resDecoded); err != nil {
-		utils.WriteErr(w, "ERROR PARSING RESPONSE FROM ELASTIC", http.StatusInternalServerError)
+	res, err := store.Search(r.Context(), "requests", query)
+	if err != nil {
+		utils.WriteStorageErr(w, err, "BAD RESPONSE FROM STORAGE WHILE SEARCHING DOCUMENTS")
+		return
+	}
+
+	hits, err := toHits(res.Hits)
+	if err != nil {
+		utils.WriteErr(w, "ERROR PARSING RESPONSE FROM STORAGE", http.StatusInternalServerError)
 		return
 	}
 
-	utils.WriteJson(w, resDecoded.Hits.Hits)
+	utils.WriteJson(w, utils.Page{Data: hits, Total: res.Total, NextCursor: utils.NextCursor(query, res.Hits)})
 }
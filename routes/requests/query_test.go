@@ -0,0 +1,140 @@
+package requests
+
+import (
+	"net/http"
+	"reflect"
+	"sofa-logs-servers/infra/storage"
+	"sofa-logs-servers/models"
+	"sofa-logs-servers/routes/routetest"
+	"sofa-logs-servers/utils"
+	"testing"
+	"time"
+)
+
+func TestFindById(t *testing.T) {
+	store := routetest.Store(t, "requests", models.LogMapping)
+	id := routetest.Index(t, store, "requests", models.Log{Page: "/home"})
+
+	var hits []Hit
+	routetest.Decode(t, routetest.Post(FindById, store, `{"request_id":"`+id+`"}`), http.StatusOK, &hits)
+	if len(hits) != 1 || hits[0].Id != id || hits[0].Source.Page != "/home" {
+		t.Errorf("hits %+v", hits)
+	}
+}
+
+// listing is the page FindAll answers with.
+type listing struct {
+	Data       []Hit  `json:"data"`
+	Total      int    `json:"total"`
+	NextCursor string `json:"next_cursor"`
+}
+
+func (l listing) pages() []string {
+	pages := make([]string, len(l.Data))
+	for i, hit := range l.Data {
+		pages[i] = hit.Source.Page
+	}
+	return pages
+}
+
+func findAll(t *testing.T, store storage.Storage, body string) listing {
+	t.Helper()
+	page := listing{}
+	routetest.Decode(t, routetest.Post(FindAll, store, body), http.StatusOK, &page)
+	return page
+}
+
+// visits stores one log per page, a minute and a second of dwell time apart.
+func visits(t *testing.T, pages ...string) storage.Storage {
+	t.Helper()
+	store := routetest.Store(t, "requests", models.LogMapping)
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	for i, page := range pages {
+		log := models.Log{Page: page, StartedAt: start.Add(time.Duration(i) * time.Minute), DurationMs: int64(i) * 1000}
+		routetest.Index(t, store, "requests", log)
+	}
+	return store
+}
+
+func TestFindAllSorts(t *testing.T) {
+	store := visits(t, "/a", "/b", "/c")
+
+	tests := []struct {
+		name  string
+		body  string
+		pages []string
+	}{
+		{"empty body", ``, []string{"/a", "/b", "/c"}},
+		{"descending", `{"order":"desc"}`, []string{"/c", "/b", "/a"}},
+		{"by duration", `{"sort":"duration_ms","order":"desc"}`, []string{"/c", "/b", "/a"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := findAll(t, store, test.body).pages(); !reflect.DeepEqual(got, test.pages) {
+				t.Errorf("pages = %v, want %v", got, test.pages)
+			}
+		})
+	}
+}
+
+func TestFindAllPages(t *testing.T) {
+	store := visits(t, "/a", "/b", "/c")
+
+	first := findAll(t, store, `{"limit":2}`)
+	if got := first.pages(); !reflect.DeepEqual(got, []string{"/a", "/b"}) || first.Total != 3 || first.NextCursor == "" {
+		t.Fatalf("first page %v, total %d, cursor %q", got, first.Total, first.NextCursor)
+	}
+
+	second := findAll(t, store, `{"limit":2,"cursor":"`+first.NextCursor+`"}`)
+	if got := second.pages(); !reflect.DeepEqual(got, []string{"/c"}) || second.NextCursor != "" {
+		t.Errorf("second page %v, cursor %q", got, second.NextCursor)
+	}
+
+	// A cursor only resumes the listing it was issued for.
+	w := routetest.Post(FindAll, store, `{"limit":2,"order":"desc","cursor":"`+first.NextCursor+`"}`)
+	if form := routetest.Error(t, w, http.StatusBadRequest); !reflect.DeepEqual(routetest.Fields(form), []string{"cursor"}) {
+		t.Errorf("details = %+v", form.Details)
+	}
+}
+
+func TestFindAllFilters(t *testing.T) {
+	store := visits(t, "/docs/a", "/docs/b", "/blog/c", "/blog/d")
+
+	tests := []struct {
+		name   string
+		filter string
+		pages  []string
+	}{
+		{"page", `{"page":"/blog/c"}`, []string{"/blog/c"}},
+		{"prefix", `{"page_prefix":"/docs/"}`, []string{"/docs/a", "/docs/b"}},
+		{"wildcard", `{"page_wildcard":"/*/d"}`, []string{"/blog/d"}},
+		{"started", `{"started_from":"2024-01-01T10:01:00Z","started_to":"2024-01-01T10:02:00Z"}`, []string{"/docs/b", "/blog/c"}},
+		{"duration", `{"min_duration":"1s","max_duration":"2s"}`, []string{"/docs/b", "/blog/c"}},
+		{"and", `{"and":[{"page_prefix":"/docs/"},{"min_duration":"1s"}]}`, []string{"/docs/b"}},
+		{"or", `{"or":[{"page":"/docs/a"},{"page":"/blog/d"}]}`, []string{"/docs/a", "/blog/d"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := findAll(t, store, `{"filter":`+test.filter+`}`).pages(); !reflect.DeepEqual(got, test.pages) {
+				t.Errorf("pages = %v, want %v", got, test.pages)
+			}
+		})
+	}
+}
+
+func TestFindAllRejectsBadFilter(t *testing.T) {
+	w := routetest.Post(FindAll, visits(t), `{"filter":{"and":[{"max_duration":"soon"}]}}`)
+	if form := routetest.Error(t, w, http.StatusBadRequest); !reflect.DeepEqual(routetest.Fields(form), []string{"filter.max_duration"}) {
+		t.Errorf("details = %+v", form.Details)
+	}
+}
+
+func TestFindAllRejectsBadPaging(t *testing.T) {
+	w := routetest.Post(FindAll, visits(t), `{"limit":-1,"order":"up"}`)
+	form := routetest.Error(t, w, http.StatusBadRequest)
+	if form.Code != utils.CodeValidation || !reflect.DeepEqual(routetest.Fields(form), []string{"limit", "order"}) {
+		t.Errorf("error %+v, want details for limit and order", form)
+	}
+}
@@ -0,0 +1,225 @@
+package requests
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"sofa-logs-servers/infra/storage"
+	"sofa-logs-servers/models"
+	"sofa-logs-servers/utils"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+const (
+	DefaultStatsPages = 10
+	MaxStatsPages     = 100
+
+	// DefaultBounceThreshold is the dwell time under which a visit counts as a bounce.
+	DefaultBounceThreshold = "10s"
+)
+
+// StatsForm selects the visits started in [From, To). Either bound may be left out.
+type StatsForm struct {
+	From            time.Time `json:"from"`
+	To              time.Time `json:"to"`
+	Limit           int       `json:"limit"`
+	BounceThreshold string    `json:"bounce_threshold"`
+}
+
+type PageStats struct {
+	Page          string   `json:"page"`
+	Visits        int      `json:"visits"`
+	UniqueUsers   int      `json:"unique_users"`
+	AvgDwellMs    *float64 `json:"avg_dwell_ms"`
+	MedianDwellMs *float64 `json:"median_dwell_ms"`
+	P95DwellMs    *float64 `json:"p95_dwell_ms"`
+	Bounces       int      `json:"bounces"`
+}
+
+type StatsRes struct {
+	TotalVisits int         `json:"total_visits"`
+	UniqueUsers int         `json:"unique_users"`
+	Pages       []PageStats `json:"pages"`
+}
+
+func (form StatsForm) filter() *storage.Filter {
+	if form.From.IsZero() && form.To.IsZero() {
+		return nil
+	}
+
+	window := &storage.Range{Field: "started_at"}
+	if !form.From.IsZero() {
+		window.Gte = form.From
+	}
+	if !form.To.IsZero() {
+		window.Lt = form.To
+	}
+	return &storage.Filter{Range: window}
+}
+
+func statsQuery(form StatsForm, bounce time.Duration, withPercentiles bool) storage.Query {
+	threshold := float64(bounce.Milliseconds())
+	perPage := map[string]storage.Aggregation{
+		"users":     {Type: storage.AggCardinality, Field: "user_id"},
+		"dwell_avg": {Type: storage.AggAvg, Field: "duration_ms"},
+		"bounces": {
+			Type:   storage.AggRange,
+			Field:  "duration_ms",
+			Ranges: []storage.AggregationRange{{To: &threshold}},
+		},
+	}
+	if withPercentiles {
+		perPage["dwell"] = storage.Aggregation{Type: storage.AggPercentiles, Field: "duration_ms", Percents: []float64{50, 95}}
+	}
+
+	return storage.Query{
+		Filter: form.filter(),
+		Aggs: map[string]storage.Aggregation{
+			"users": {Type: storage.AggCardinality, Field: "user_id"},
+			"pages": {Type: storage.AggTerms, Field: "page", Size: form.Limit, Aggs: perPage},
+		},
+	}
+}
+
+// dwellPercentiles computes the median and p95 dwell time of each of pages for backends that
+// cannot aggregate percentiles. The visits of all the pages are read in a single scan of the
+// window and grouped by page here.
+func dwellPercentiles(r *http.Request, store storage.Storage, form StatsForm, pages []string) (map[string]map[float64]float64, error) {
+	filter := storage.Filter{}
+	for _, page := range pages {
+		filter.Or = append(filter.Or, storage.Filter{Term: &storage.Term{Field: "page", Value: page}})
+	}
+	if window := form.filter(); window != nil {
+		filter = storage.Filter{And: []storage.Filter{filter, *window}}
+	}
+
+	// Paging needs a stable order, which backends complete with the document id.
+	query := storage.Query{Filter: &filter, Sort: []storage.SortField{{Field: "started_at"}}}
+	hits, err := storage.SearchAll(r.Context(), store, "requests", query)
+	if err != nil {
+		return nil, err
+	}
+
+	durations := map[string][]float64{}
+	for _, hit := range hits {
+		log := models.Log{}
+		if err := jsoniter.Unmarshal(hit.Source, &log); err != nil {
+			return nil, err
+		}
+		durations[log.Page] = append(durations[log.Page], float64(log.DurationMs))
+	}
+
+	percentiles := make(map[string]map[float64]float64, len(pages))
+	for _, page := range pages {
+		percentiles[page] = storage.Percentiles(durations[page], []float64{50, 95})
+	}
+	return percentiles, nil
+}
+
+func count(result storage.AggregationResult) int {
+	if result.Value == nil {
+		return 0
+	}
+	return int(*result.Value)
+}
+
+func Stats(w http.ResponseWriter, r *http.Request, store storage.Storage) {
+	defer func() {
+		err := r.Body.Close()
+		if err != nil {
+			panic(err)
+		}
+	}()
+
+	form := StatsForm{}
+	err := jsoniter.NewDecoder(r.Body).Decode(&form)
+	if err != nil && err != io.EOF {
+		utils.WriteBadBody(w)
+		return
+	}
+
+	if form.Limit == 0 {
+		form.Limit = DefaultStatsPages
+	}
+	if form.Limit < 0 || form.Limit > MaxStatsPages {
+		utils.WriteValidationErr(w, utils.Invalid("limit", "limit MUST BE BETWEEN 1 AND 100"))
+		return
+	}
+
+	if !form.From.IsZero() && !form.To.IsZero() && !form.From.Before(form.To) {
+		utils.WriteValidationErr(w, utils.Invalid("from", "from MUST BE BEFORE to"))
+		return
+	}
+
+	if form.BounceThreshold == "" {
+		form.BounceThreshold = DefaultBounceThreshold
+	}
+	bounce, err := time.ParseDuration(form.BounceThreshold)
+	if err != nil || bounce <= 0 {
+		utils.WriteValidationErr(w, utils.Invalid("bounce_threshold", "INVALID bounce_threshold"))
+		return
+	}
+
+	withPercentiles := true
+	res, err := store.Search(r.Context(), "requests", statsQuery(form, bounce, withPercentiles))
+	if errors.Is(err, storage.ErrUnsupported) {
+		withPercentiles = false
+		res, err = store.Search(r.Context(), "requests", statsQuery(form, bounce, withPercentiles))
+	}
+	if err != nil {
+		utils.WriteStorageErr(w, err, "BAD RESPONSE FROM STORAGE WHILE AGGREGATING DOCUMENTS")
+		return
+	}
+
+	buckets := res.Aggregations["pages"].Buckets
+	var scanned map[string]map[float64]float64
+	if !withPercentiles && len(buckets) > 0 {
+		pages := make([]string, 0, len(buckets))
+		for _, bucket := range buckets {
+			page, _ := bucket.Key.(string)
+			pages = append(pages, page)
+		}
+		scanned, err = dwellPercentiles(r, store, form, pages)
+		if err != nil {
+			utils.WriteStorageErr(w, err, "BAD RESPONSE FROM STORAGE WHILE AGGREGATING DOCUMENTS")
+			return
+		}
+	}
+
+	stats := StatsRes{
+		TotalVisits: res.Total,
+		UniqueUsers: count(res.Aggregations["users"]),
+		Pages:       []PageStats{},
+	}
+
+	for _, bucket := range buckets {
+		page, _ := bucket.Key.(string)
+		pageStats := PageStats{
+			Page:        page,
+			Visits:      bucket.Count,
+			UniqueUsers: count(bucket.Aggs["users"]),
+			AvgDwellMs:  bucket.Aggs["dwell_avg"].Value,
+		}
+
+		if bounces := bucket.Aggs["bounces"].Buckets; len(bounces) > 0 {
+			pageStats.Bounces = bounces[0].Count
+		}
+
+		dwell := bucket.Aggs["dwell"].Percentiles
+		if !withPercentiles {
+			dwell = scanned[page]
+		}
+		if median, ok := dwell[50]; ok {
+			pageStats.MedianDwellMs = &median
+		}
+		if p95, ok := dwell[95]; ok {
+			pageStats.P95DwellMs = &p95
+		}
+
+		stats.Pages = append(stats.Pages, pageStats)
+	}
+
+	utils.WriteJson(w, stats)
+}
@@ -0,0 +1,78 @@
+package requests
+
+import (
+	"context"
+	"net/http"
+	"sofa-logs-servers/infra/storage"
+	"sofa-logs-servers/models"
+	"sofa-logs-servers/routes/routetest"
+	"testing"
+	"time"
+)
+
+// withoutPercentiles refuses percentile aggregations like Zinc does and counts the scans made
+// instead.
+type withoutPercentiles struct {
+	storage.Storage
+	scans int
+}
+
+func hasPercentiles(aggs map[string]storage.Aggregation) bool {
+	for _, agg := range aggs {
+		if agg.Type == storage.AggPercentiles || hasPercentiles(agg.Aggs) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *withoutPercentiles) Search(ctx context.Context, index string, q storage.Query) (storage.SearchResult, error) {
+	if hasPercentiles(q.Aggs) {
+		return storage.SearchResult{}, storage.ErrUnsupported
+	}
+	if len(q.Aggs) == 0 {
+		s.scans++
+	}
+	return s.Storage.Search(ctx, index, q)
+}
+
+func TestStatsPercentiles(t *testing.T) {
+	store := routetest.Store(t, "requests", models.LogMapping)
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	durations := map[string][]int64{"/a": {1000, 2000, 3000}, "/b": {5000, 7000}}
+	for page, ms := range durations {
+		for i, d := range ms {
+			log := models.Log{UserID: uint(i + 1), Page: page, StartedAt: start.Add(time.Duration(i) * time.Hour), DurationMs: d}
+			routetest.Index(t, store, "requests", log)
+		}
+	}
+	// Outside the window and left out of every percentile.
+	routetest.Index(t, store, "requests", models.Log{Page: "/a", StartedAt: start.AddDate(0, 0, 1), DurationMs: 90000})
+
+	want := map[string][2]float64{"/a": {2000, 2900}, "/b": {6000, 6900}}
+	body := `{"from":"2024-01-01T00:00:00Z","to":"2024-01-02T00:00:00Z"}`
+
+	scanning := &withoutPercentiles{Storage: store}
+	backends := map[string]storage.Storage{"aggregated": store, "scanned": scanning}
+	for name, backend := range backends {
+		t.Run(name, func(t *testing.T) {
+			res := StatsRes{}
+			routetest.Decode(t, routetest.Post(Stats, backend, body), http.StatusOK, &res)
+			if len(res.Pages) != len(want) {
+				t.Fatalf("pages %+v", res.Pages)
+			}
+			for _, page := range res.Pages {
+				if page.MedianDwellMs == nil || page.P95DwellMs == nil {
+					t.Fatalf("%s has no percentiles", page.Page)
+				}
+				if got := [2]float64{*page.MedianDwellMs, *page.P95DwellMs}; got != want[page.Page] {
+					t.Errorf("%s median and p95 = %v, want %v", page.Page, got, want[page.Page])
+				}
+			}
+		})
+	}
+
+	if scanning.scans != 1 {
+		t.Errorf("%d scans, want a single one for all pages", scanning.scans)
+	}
+}
@@ -0,0 +1,108 @@
+// Package routetest runs route handlers against an in-memory embedded store.
+package routetest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sofa-logs-servers/infra/embedded"
+	"sofa-logs-servers/infra/storage"
+	"sofa-logs-servers/utils"
+	"strings"
+	"testing"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// Handler is the signature of the handlers utils.Middleware serves.
+type Handler func(w http.ResponseWriter, r *http.Request, store storage.Storage)
+
+// Store returns an in-memory store holding one empty index.
+func Store(t testing.TB, index string, mapping storage.Mapping) *embedded.Store {
+	t.Helper()
+	store, err := embedded.Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.EnsureIndex(context.Background(), index, mapping); err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+// Index stores document in index and returns its id.
+func Index(t testing.TB, store storage.Storage, index string, document interface{}) string {
+	t.Helper()
+	res, err := store.Index(context.Background(), index, document)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.ID
+}
+
+// Count returns the number of documents in index.
+func Count(t testing.TB, store storage.Storage, index string) int {
+	t.Helper()
+	res, err := store.Search(context.Background(), index, storage.Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.Total
+}
+
+// Source decodes the stored document id of index into v.
+func Source(t testing.TB, store storage.Storage, index, id string, v interface{}) {
+	t.Helper()
+	hit, err := store.Get(context.Background(), index, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := jsoniter.Unmarshal(hit.Source, v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Request returns a POST request with body.
+func Request(body string) *http.Request {
+	return httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+}
+
+// Serve runs handler on r and returns the recorded response.
+func Serve(handler Handler, store storage.Storage, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	handler(w, r, store)
+	return w
+}
+
+// Post runs handler on a POST of body.
+func Post(handler Handler, store storage.Storage, body string) *httptest.ResponseRecorder {
+	return Serve(handler, store, Request(body))
+}
+
+// Decode decodes the body of w into v, failing the test unless w has status.
+func Decode(t testing.TB, w *httptest.ResponseRecorder, status int, v interface{}) {
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf("status = %d, want %d, body %s", w.Code, status, w.Body)
+	}
+	if err := jsoniter.NewDecoder(w.Body).Decode(v); err != nil {
+		t.Fatalf("decoding %s: %v", w.Body, err)
+	}
+}
+
+// Error decodes the error body of w, failing the test unless w has status.
+func Error(t testing.TB, w *httptest.ResponseRecorder, status int) utils.ErrorForm {
+	t.Helper()
+	form := utils.ErrorForm{}
+	Decode(t, w, status, &form)
+	return form
+}
+
+// Fields lists the fields the details of form name, in order.
+func Fields(form utils.ErrorForm) []string {
+	fields := make([]string, len(form.Details))
+	for i, detail := range form.Details {
+		fields[i] = detail.Field
+	}
+	return fields
+}
@@ -0,0 +1,153 @@
+package transactions
+
+import (
+	"io"
+	"net/http"
+	"sofa-logs-servers/infra/storage"
+	"sofa-logs-servers/utils"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+var intervals = []storage.Interval{storage.IntervalHour, storage.IntervalDay, storage.IntervalWeek, storage.IntervalMonth}
+
+// HistogramForm buckets the transactions dated in [StartDate, EndDate) by Interval, with buckets
+// starting in TimeZone. A zero StartDate or EndDate leaves that side of the window open.
+type HistogramForm struct {
+	StartDate time.Time        `json:"start_date"`
+	EndDate   time.Time        `json:"end_date"`
+	Interval  storage.Interval `json:"interval"`
+	TimeZone  string           `json:"time_zone"`
+}
+
+type HistogramBucket struct {
+	Start time.Time `json:"start"`
+	Count int       `json:"count"`
+	Sum   float64   `json:"sum"`
+	Min   *float64  `json:"min"`
+	Max   *float64  `json:"max"`
+	Avg   *float64  `json:"avg"`
+}
+
+type HistogramRes struct {
+	Interval storage.Interval  `json:"interval"`
+	TimeZone string            `json:"time_zone"`
+	Buckets  []HistogramBucket `json:"buckets"`
+}
+
+func (form HistogramForm) filter() *storage.Filter {
+	if form.StartDate.IsZero() && form.EndDate.IsZero() {
+		return nil
+	}
+
+	dates := &storage.Range{Field: "date"}
+	if !form.StartDate.IsZero() {
+		dates.Gte = form.StartDate
+	}
+	if !form.EndDate.IsZero() {
+		dates.Lt = form.EndDate
+	}
+	return &storage.Filter{Range: dates}
+}
+
+// bucketStart reads a date histogram key, which backends return either as epoch milliseconds or
+// as a formatted date.
+func bucketStart(key interface{}, location *time.Location) (time.Time, bool) {
+	switch key := key.(type) {
+	case float64:
+		return time.UnixMilli(int64(key)).In(location), true
+	case string:
+		start, err := time.Parse(time.RFC3339Nano, key)
+		return start.In(location), err == nil
+	}
+	return time.Time{}, false
+}
+
+func Histogram(w http.ResponseWriter, r *http.Request, store storage.Storage) {
+	defer func() {
+		err := r.Body.Close()
+		if err != nil {
+			panic(err)
+		}
+	}()
+
+	form := HistogramForm{}
+	err := jsoniter.NewDecoder(r.Body).Decode(&form)
+	if err != nil && err != io.EOF {
+		utils.WriteBadBody(w)
+		return
+	}
+
+	if !form.StartDate.IsZero() && !form.EndDate.IsZero() && !form.StartDate.Before(form.EndDate) {
+		utils.WriteValidationErr(w, utils.Invalid("start_date", "start_date MUST BE BEFORE end_date"))
+		return
+	}
+
+	if form.Interval == "" {
+		form.Interval = storage.IntervalDay
+	}
+	valid := false
+	for _, interval := range intervals {
+		valid = valid || form.Interval == interval
+	}
+	if !valid {
+		utils.WriteValidationErr(w, utils.Invalid("interval", "interval MUST BE hour, day, week OR month"))
+		return
+	}
+
+	if form.TimeZone == "" {
+		form.TimeZone = "UTC"
+	}
+	location, err := time.LoadLocation(form.TimeZone)
+	if err != nil {
+		utils.WriteValidationErr(w, utils.Invalid("time_zone", "INVALID time_zone"))
+		return
+	}
+
+	query := storage.Query{
+		Filter: form.filter(),
+		Aggs: map[string]storage.Aggregation{
+			"histogram": {
+				Type:     storage.AggDateHistogram,
+				Field:    "date",
+				Interval: form.Interval,
+				TimeZone: form.TimeZone,
+				Aggs: map[string]storage.Aggregation{
+					"sum": {Type: storage.AggSum, Field: "amount"},
+					"min": {Type: storage.AggMin, Field: "amount"},
+					"max": {Type: storage.AggMax, Field: "amount"},
+					"avg": {Type: storage.AggAvg, Field: "amount"},
+				},
+			},
+		},
+	}
+
+	res, err := store.Search(r.Context(), "transactions", query)
+	if err != nil {
+		utils.WriteStorageErr(w, err, "BAD RESPONSE FROM STORAGE WHILE AGGREGATING DOCUMENTS")
+		return
+	}
+
+	histogram := HistogramRes{Interval: form.Interval, TimeZone: form.TimeZone, Buckets: []HistogramBucket{}}
+	for _, bucket := range res.Aggregations["histogram"].Buckets {
+		start, ok := bucketStart(bucket.Key, location)
+		if !ok {
+			utils.WriteErr(w, "ERROR PARSING RESPONSE FROM STORAGE", http.StatusInternalServerError)
+			return
+		}
+
+		histogramBucket := HistogramBucket{Start: start, Count: bucket.Count}
+		if bucket.Count > 0 {
+			histogramBucket.Min = bucket.Aggs["min"].Value
+			histogramBucket.Max = bucket.Aggs["max"].Value
+			histogramBucket.Avg = bucket.Aggs["avg"].Value
+		}
+		if sum := bucket.Aggs["sum"].Value; sum != nil {
+			histogramBucket.Sum = *sum
+		}
+		histogram.Buckets = append(histogram.Buckets, histogramBucket)
+	}
+
+	utils.WriteJson(w, histogram)
+}
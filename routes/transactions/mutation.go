@@ -3,7 +3,8 @@ package transactions
 import (
 	jsoniter "github.com/json-iterator/go"
 	"net/http"
-	"sofa-logs-servers/infra/zincsearch"
+	"sofa-logs-servers/infra/storage"
+	"sofa-logs-servers/models"
 	"sofa-logs-servers/utils"
 	"time"
 )
@@ -52,7 +53,34 @@ type CreateRes struct {
 	PrimaryTerm int `json:"_primary_term"`
 }
 
-func Create(w http.ResponseWriter, r *http.Request, zincClient zincsearch.ZincClient) {
+// validate applies the rules every new transaction must satisfy.
+func (form CreateForm) validate() error {
+	return utils.Validate(
+		utils.Date("date", form.Date),
+		utils.RequiredNumber("amount", form.Amount),
+	)
+}
+
+// validate applies the rules of CreateForm to the new version of a transaction, which keeps the
+// time it was first recorded at.
+func (form UpdateForm) validate() error {
+	return utils.Validate(
+		utils.Required("transaction_id", form.ID),
+		utils.Date("date", form.Date),
+		utils.RequiredNumber("amount", form.Amount),
+		utils.Date("created_at", form.CreatedAt),
+	)
+}
+
+func (form CreateForm) document() models.Transaction {
+	return models.Transaction{
+		Amount:    form.Amount,
+		Date:      form.Date,
+		CreatedAt: time.Now(),
+	}
+}
+
+func Create(w http.ResponseWriter, r *http.Request, store storage.Storage) {
 
 	defer func() {
 		err := r.Body.Close()
@@ -64,46 +92,29 @@ func Create(w http.ResponseWriter, r *http.Request, zincClient zincsearch.ZincCl
 	form := CreateForm{}
 	err := jsoniter.NewDecoder(r.Body).Decode(&form)
 	if err != nil {
-		utils.WriteErr(w, "BAD BODY FORMAT", http.StatusBadRequest)
+		utils.WriteBadBody(w)
 		return
 	}
 
-	if form.Date.IsZero() {
-		utils.WriteErr(w, "EMPTY DATE", http.StatusBadRequest)
+	if err := form.validate(); err != nil {
+		utils.WriteValidationErr(w, err)
 		return
 	}
 
-	if form.Amount == 0 {
-		utils.WriteErr(w, "EMPTY AMOUNT", http.StatusBadRequest)
-	}
-
-	document := map[string]interface{}{
-		"Amount":    form.Amount,
-		"Date":      form.Date,
-		"CreatedAt": time.Now(),
-	} // map[string]interface{} | Document
-
-	_, res, err := zincClient.Client.Document.Index(zincClient.Ctx, "transactions").Document(document).Execute()
-
+	result, err := store.Index(r.Context(), "transactions", form.document())
 	if err != nil {
-		utils.WriteErr(w, "Could not send Request to zinc-search Client", http.StatusBadRequest)
-		return
-	}
-
-	if res.StatusCode != 200 {
-		utils.WriteErr(w, "BAD RESPONSE FROM ZINC WHILE CREATING DOCUMENT", http.StatusBadRequest)
+		utils.WriteStorageErr(w, err, "BAD RESPONSE FROM STORAGE WHILE CREATING DOCUMENT")
 		return
 	}
 
-	respDecoded := CreateRes{}
-	err = jsoniter.NewDecoder(res.Body).Decode(&respDecoded)
-	if err != nil {
-		utils.WriteErr(w, "ERROR PARSING RESPONSE FROM ELASTIC", http.StatusInternalServerError)
+	status := http.StatusOK
+	if result.Result == storage.ResultQueued {
+		status = http.StatusAccepted
 	}
-	utils.WriteJson(w, respDecoded)
+	utils.WriteJsonStatus(w, CreateRes{Index: "transactions", Id: result.ID, Result: result.Result}, status)
 }
 
-func Update(w http.ResponseWriter, r *http.Request, zincClient zincsearch.ZincClient) {
+func Update(w http.ResponseWriter, r *http.Request, store storage.Storage) {
 	defer func() {
 		err := r.Body.Close()
 		if err != nil {
@@ -115,55 +126,62 @@ func Update(w http.ResponseWriter, r *http.Request, zincClient zincsearch.ZincCl
 
 	err := jsoniter.NewDecoder(r.Body).Decode(&form)
 	if err != nil {
-		utils.WriteErr(w, "BAD BODY FORMAT", http.StatusBadRequest)
+		utils.WriteBadBody(w)
 		return
 	}
 
-	//
-
-	if form.ID == "" {
-		utils.WriteErr(w, "transaction_id IS REQUIRED", http.StatusBadRequest)
+	if err := form.validate(); err != nil {
+		utils.WriteValidationErr(w, err)
 		return
 	}
 
-	if form.Date.IsZero() {
-		utils.WriteErr(w, "DATE IS REQUIRED", http.StatusBadRequest)
-		return
+	document := models.Transaction{
+		Amount:    form.Amount,
+		Date:      form.Date,
+		CreatedAt: form.CreatedAt,
 	}
 
-	if form.Amount == 0 {
-		utils.WriteErr(w, "AMOUNT IS REQUIRED", http.StatusBadRequest)
+	err = store.Update(r.Context(), "transactions", form.ID, document)
+	if err != nil {
+		utils.WriteStorageErr(w, err, "BAD RESPONSE FROM STORAGE WHILE UPDATING DOCUMENT")
 		return
 	}
 
-	if form.CreatedAt.IsZero() {
-		utils.WriteErr(w, "CREATED_AT IS REQUIRED", http.StatusBadRequest)
-		return
-	}
+	utils.WriteJson(w, "Log Updated")
 
-	document := map[string]interface{}{
-		"Date":       form.Date,
-		"Amount":     form.Amount,
-		"created_at": form.CreatedAt,
-	} // map[string]interface{} | Document
+}
 
-	_, res, err := zincClient.Client.Document.Update(zincClient.Ctx, "transactions", form.ID).Document(document).Execute()
+func Delete(w http.ResponseWriter, r *http.Request, store storage.Storage) {
+	defer func() {
+		err := r.Body.Close()
+		if err != nil {
+			panic(err)
+		}
+	}()
 
+	form := DeleteFrom{}
+	err := jsoniter.NewDecoder(r.Body).Decode(&form)
 	if err != nil {
-		utils.WriteErr(w, "Could not send Request to zinc-search Client", http.StatusBadRequest)
+		utils.WriteBadBody(w)
 		return
 	}
 
-	if res.StatusCode != 200 {
-		utils.WriteErr(w, "BAD RESPONSE FROM ZINC WHILE UPDATING DOCUMENT", http.StatusBadRequest)
+	if form.ID == "" {
+		utils.WriteValidationErr(w, utils.Invalid("transaction_id", "transaction_id IS REQUIRED"))
 		return
 	}
 
-	utils.WriteJson(w, "Log Updated")
+	err = store.Delete(r.Context(), "transactions", form.ID)
+	if err != nil {
+		utils.WriteStorageErr(w, err, "BAD RESPONSE FROM STORAGE WHILE DELETING DOCUMENT")
+		return
+	}
 
+	utils.WriteJson(w, "Document Deleted")
 }
 
-func Delete(w http.ResponseWriter, r *http.Request, zincClient zincsearch.ZincClient) {
+// Bulk indexes a JSON array or NDJSON stream of transactions, validating each one like Create.
+func Bulk(w http.ResponseWriter, r *http.Request, store storage.Storage) {
 	defer func() {
 		err := r.Body.Close()
 		if err != nil {
@@ -171,28 +189,56 @@ func Delete(w http.ResponseWriter, r *http.Request, zincClient zincsearch.ZincCl
 		}
 	}()
 
-	form := DeleteFrom{}
-	err := jsoniter.NewDecoder(r.Body).Decode(&form)
+	items, err := utils.DecodeBulk(w, r)
 	if err != nil {
-		utils.WriteErr(w, "BAD BODY FORMAT", http.StatusBadRequest)
+		utils.WriteBulkErr(w, err)
 		return
 	}
 
-	if form.ID == "" {
-		utils.WriteErr(w, "request_id IS REQUIRED", http.StatusBadRequest)
-		return
+	response := utils.BulkResponse{Items: make([]utils.BulkItem, len(items))}
+	var documents []storage.Document
+	var positions []int
+
+	for i, item := range items {
+		response.Items[i].Position = i
+
+		form := CreateForm{}
+		if err := jsoniter.Unmarshal(item, &form); err != nil {
+			response.Items[i].Code, response.Items[i].Error = utils.CodeBadBody, "BAD BODY FORMAT"
+			continue
+		}
+
+		if err := form.validate(); err != nil {
+			response.Items[i].Code, response.Items[i].Error = utils.CodeValidation, err.Error()
+			continue
+		}
+
+		documents = append(documents, storage.Document{Source: form.document()})
+		positions = append(positions, i)
 	}
 
-	_, res, err := zincClient.Client.Document.Delete(zincClient.Ctx, "transactions", form.ID).Execute()
+	stored, err := store.Bulk(r.Context(), "transactions", documents)
 	if err != nil {
-		utils.WriteErr(w, "Error deleting the Document", http.StatusBadRequest)
+		utils.WriteStorageErr(w, err, "BAD RESPONSE FROM STORAGE WHILE CREATING DOCUMENTS")
 		return
 	}
 
-	if res.StatusCode != 200 {
-		utils.WriteErr(w, "BAD RESPONSE FROM ZINC WHILE DELETING DOCUMENT", http.StatusBadRequest)
-		return
+	for j, item := range stored {
+		if item.Err != nil {
+			response.Items[positions[j]].Code = utils.CodeStorage
+			response.Items[positions[j]].Error = "COULD NOT STORE DOCUMENT"
+			continue
+		}
+		response.Items[positions[j]].ID = item.ID
+		response.Items[positions[j]].Result = item.Result
 	}
 
-	utils.WriteJson(w, "Document Deleted")
+	status := http.StatusOK
+	for _, item := range response.Items {
+		response.Errors = response.Errors || item.Error != ""
+		if item.Result == storage.ResultQueued {
+			status = http.StatusAccepted
+		}
+	}
+	utils.WriteJsonStatus(w, response, status)
 }
@@ -0,0 +1,123 @@
+package transactions
+
+import (
+	"net/http"
+	"reflect"
+	"sofa-logs-servers/models"
+	"sofa-logs-servers/routes/routetest"
+	"sofa-logs-servers/utils"
+	"testing"
+	"time"
+)
+
+func TestCreate(t *testing.T) {
+	store := routetest.Store(t, "transactions", models.TransactionMapping)
+
+	res := CreateRes{}
+	routetest.Decode(t, routetest.Post(Create, store, `{"amount":1200,"date":"2024-01-01T10:00:00Z"}`), http.StatusOK, &res)
+
+	transaction := models.Transaction{}
+	routetest.Source(t, store, "transactions", res.Id, &transaction)
+	if transaction.Amount != 1200 || transaction.CreatedAt.IsZero() {
+		t.Errorf("stored %+v", transaction)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	store := routetest.Store(t, "transactions", models.TransactionMapping)
+	createdAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	id := routetest.Index(t, store, "transactions", models.Transaction{Amount: 1, Date: createdAt, CreatedAt: createdAt})
+
+	body := `{"transaction_id":"` + id + `","amount":5,"date":"2024-01-02T00:00:00Z","created_at":"2024-01-01T00:00:00Z"}`
+	if w := routetest.Post(Update, store, body); w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+
+	transaction := models.Transaction{}
+	routetest.Source(t, store, "transactions", id, &transaction)
+	if transaction.Amount != 5 || !transaction.CreatedAt.Equal(createdAt) {
+		t.Errorf("stored %+v", transaction)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	store := routetest.Store(t, "transactions", models.TransactionMapping)
+	id := routetest.Index(t, store, "transactions", models.Transaction{Amount: 1})
+
+	if w := routetest.Post(Delete, store, `{"transaction_id":"`+id+`"}`); w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+	if n := routetest.Count(t, store, "transactions"); n != 0 {
+		t.Errorf("%d documents left", n)
+	}
+}
+
+func TestBulk(t *testing.T) {
+	store := routetest.Store(t, "transactions", models.TransactionMapping)
+
+	res := utils.BulkResponse{}
+	body := `[{"amount":1,"date":"2024-01-01T10:00:00Z"},{"amount":0,"date":"2024-01-01T10:00:00Z"},{"amount":2,"date":"2024-01-02T10:00:00Z"}]`
+	routetest.Decode(t, routetest.Post(Bulk, store, body), http.StatusOK, &res)
+	if !res.Errors || len(res.Items) != 3 {
+		t.Fatalf("response %+v", res)
+	}
+	if res.Items[0].ID == "" || res.Items[1].Code != utils.CodeValidation || res.Items[2].ID == "" {
+		t.Errorf("items %+v", res.Items)
+	}
+	if n := routetest.Count(t, store, "transactions"); n != 2 {
+		t.Errorf("%d documents stored, want 2", n)
+	}
+}
+
+func TestErrorCodes(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler routetest.Handler
+		body    string
+		status  int
+		code    utils.ErrorCode
+	}{
+		{"update missing", Update, `{"transaction_id":"missing","amount":5,"date":"2024-01-02T00:00:00Z","created_at":"2024-01-01T00:00:00Z"}`, http.StatusNotFound, utils.CodeNotFound},
+		{"delete missing", Delete, `{"transaction_id":"missing"}`, http.StatusNotFound, utils.CodeNotFound},
+		{"find missing", FindById, `{"transaction_id":"missing"}`, http.StatusNotFound, utils.CodeNotFound},
+		{"create bad body", Create, `{"amount":`, http.StatusBadRequest, utils.CodeBadBody},
+		{"update bad body", Update, `[]`, http.StatusBadRequest, utils.CodeBadBody},
+		{"delete bad body", Delete, ``, http.StatusBadRequest, utils.CodeBadBody},
+		{"list bad body", FindAll, `{"limit":"ten"}`, http.StatusBadRequest, utils.CodeBadBody},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := routetest.Post(test.handler, routetest.Store(t, "transactions", models.TransactionMapping), test.body)
+			if form := routetest.Error(t, w, test.status); form.Code != test.code {
+				t.Errorf("code = %s, want %s", form.Code, test.code)
+			}
+		})
+	}
+}
+
+func TestCreateRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		fields []string
+	}{
+		{"empty", `{}`, []string{"date", "amount"}},
+		{"zero amount", `{"amount":0,"date":"2024-01-01T10:00:00Z"}`, []string{"amount"}},
+		{"future date", `{"amount":1,"date":"2999-01-01T00:00:00Z"}`, []string{"date"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := routetest.Store(t, "transactions", models.TransactionMapping)
+
+			form := routetest.Error(t, routetest.Post(Create, store, test.body), http.StatusBadRequest)
+			if form.Code != utils.CodeValidation || !reflect.DeepEqual(routetest.Fields(form), test.fields) {
+				t.Errorf("error %+v, want fields %v", form, test.fields)
+			}
+			if n := routetest.Count(t, store, "transactions"); n != 0 {
+				t.Errorf("%d documents stored", n)
+			}
+		})
+	}
+}
@@ -1,23 +1,29 @@
 package transactions
 
 import (
-	"encoding/json"
 	jsoniter "github.com/json-iterator/go"
-	zinc "github.com/zinclabs/sdk-go-zincsearch"
+	"io"
 	"net/http"
-	"sofa-logs-servers/infra/zincsearch"
+	"sofa-logs-servers/infra/storage"
 	"sofa-logs-servers/models"
 	"sofa-logs-servers/utils"
 	"time"
 )
 
+var sortable = []string{"amount", "date", "created_at"}
+
 type FindByIdForm struct {
 	TransactionID string `json:"transaction_id"`
 }
 
+// FindAllForm selects a page of transactions by date. A zero StartDate or EndDate leaves that side
+// of the window open, and both bounds are exclusive unless IncludeStart or IncludeEnd is set.
 type FindAllForm struct {
-	StartDate time.Time `json:"start_date"`
-	EndDate   time.Time `json:"end_date"`
+	utils.PageForm
+	StartDate    time.Time `json:"start_date"`
+	EndDate      time.Time `json:"end_date"`
+	IncludeStart bool      `json:"include_start"`
+	IncludeEnd   bool      `json:"include_end"`
 }
 
 type FindAllReturn struct {
@@ -28,138 +34,138 @@ type FindAllReturn struct {
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
-type RespFind struct {
-	Took     int         `json:"took"`
-	TimedOut bool        `json:"timed_out"`
-	Shards   interface{} `json:"_shards"`
-	Hits     struct {
-		Total struct {
-			Value    int    `json:"value"`
-			Relation string `json:"relation"`
-		} `json:"total"`
-		MaxScore float64 `json:"max_score"`
-		Hits     []struct {
-			Index     string             `json:"_index"`
-			Id        string             `json:"_id"`
-			Score     float64            `json:"_score"`
-			Timestamp time.Time          `json:"@timestamp"`
-			Source    models.Transaction `json:"_source"`
+type Hit struct {
+	Index     string             `json:"_index"`
+	Id        string             `json:"_id"`
+	Score     float64            `json:"_score"`
+	Timestamp time.Time          `json:"@timestamp"`
+	Source    models.Transaction `json:"_source"`
+}
+
+func toHits(storageHits []storage.Hit) ([]Hit, error) {
+	hits := make([]Hit, 0, len(storageHits))
+	for _, hit := range storageHits {
+		transaction := models.Transaction{}
+		if err := jsoniter.Unmarshal(hit.Source, &transaction); err != nil {
+			return nil, err
 		}
+
+		hits = append(hits, Hit{
+			Index:     hit.Index,
+			Id:        hit.ID,
+			Score:     hit.Score,
+			Timestamp: hit.Timestamp,
+			Source:    transaction,
+		})
 	}
+	return hits, nil
 }
 
-func FindAll(w http.ResponseWriter, r *http.Request, zincClient zincsearch.ZincClient) {
+func dateFilter(form FindAllForm) *storage.Filter {
+	if form.StartDate.IsZero() && form.EndDate.IsZero() {
+		return nil
+	}
+
+	dates := &storage.Range{Field: "date"}
+
+	if !form.StartDate.IsZero() {
+		if form.IncludeStart {
+			dates.Gte = form.StartDate
+		} else {
+			dates.Gt = form.StartDate
+		}
+	}
+
+	if !form.EndDate.IsZero() {
+		if form.IncludeEnd {
+			dates.Lte = form.EndDate
+		} else {
+			dates.Lt = form.EndDate
+		}
+	}
+	return &storage.Filter{Range: dates}
+}
+
+func FindAll(w http.ResponseWriter, r *http.Request, store storage.Storage) {
 	defer func() {
 		err := r.Body.Close()
 		if err != nil {
-			utils.WriteErr(w, "ERROR IN REQUEST", http.StatusInternalServerError)
-			return
+			panic(err)
 		}
 	}()
 
 	form := FindAllForm{}
 
 	err := jsoniter.NewDecoder(r.Body).Decode(&form)
+	if err != nil && err != io.EOF {
+		utils.WriteBadBody(w)
+		return
+	}
+	query, err := utils.PageQuery(form.PageForm, sortable, storage.SortField{Field: "date"})
 	if err != nil {
-		utils.WriteErr(w, "WRONG BODY FORMAT", http.StatusBadRequest)
+		utils.WriteValidationErr(w, err)
 		return
 	}
-	query := *zinc.NewMetaZincQuery() // V1ZincQuery | Query
-	subQuery := *zinc.NewMetaQuery()
-	subQuery.SetMatchAll(map[string]interface {
-	}{})
-
-	query.SetQuery(subQuery)
-	query.SetSort([]string{"+date"})
-
-	_, res, err := zincClient.Client.Search.Search(zincClient.Ctx, "transactions").Query(query).Execute()
+	query.Filter = dateFilter(form)
 
+	res, err := store.Search(r.Context(), "transactions", query)
 	if err != nil {
-		utils.WriteErr(w, "Error searching the Document", http.StatusBadRequest)
+		utils.WriteStorageErr(w, err, "BAD RESPONSE FROM STORAGE WHILE SEARCHING DOCUMENTS")
 		return
 	}
 
-	defer func() {
-		err := res.Body.Close()
-		if err != nil {
-			panic(err)
-		}
-	}()
-
-	resDecoded := RespFind{}
-	if err := json.NewDecoder(res.Body).Decode(&resDecoded); err != nil {
-		utils.WriteErr(w, "ERROR PARSING RESPONSE FROM ELASTIC", http.StatusInternalServerError)
+	hits, err := toHits(res.Hits)
+	if err != nil {
+		utils.WriteErr(w, "ERROR PARSING RESPONSE FROM STORAGE", http.StatusInternalServerError)
 		return
 	}
 
-	var returnedArray []FindAllReturn
-
-	for _, hit := range resDecoded.Hits.Hits {
-		if hit.Source.Date.After(form.StartDate) && hit.Source.Date.Before(form.EndDate) {
-			returnedArray = append(returnedArray, FindAllReturn{
-				ID:        hit.Id,
-				Amount:    hit.Source.Amount,
-				Date:      hit.Source.Date,
-				CreatedAt: hit.Source.CreatedAt,
-				UpdatedAt: hit.Timestamp,
-			})
-		}
+	returnedArray := make([]FindAllReturn, 0, len(hits))
+	for _, hit := range hits {
+		returnedArray = append(returnedArray, FindAllReturn{
+			ID:        hit.Id,
+			Amount:    hit.Source.Amount,
+			Date:      hit.Source.Date,
+			CreatedAt: hit.Source.CreatedAt,
+			UpdatedAt: hit.Timestamp,
+		})
 	}
 
-	utils.WriteJson(w, returnedArray)
+	utils.WriteJson(w, utils.Page{Data: returnedArray, Total: res.Total, NextCursor: utils.NextCursor(query, res.Hits)})
 }
 
-func FindById(w http.ResponseWriter, r *http.Request, zincClient zincsearch.ZincClient) {
+func FindById(w http.ResponseWriter, r *http.Request, store storage.Storage) {
 	defer func() {
 		err := r.Body.Close()
 		if err != nil {
-			utils.WriteErr(w, "ERROR IN REQUEST", http.StatusInternalServerError)
-			return
+			panic(err)
 		}
 	}()
 
 	form := FindByIdForm{}
 	err := jsoniter.NewDecoder(r.Body).Decode(&form)
 	if err != nil {
-		utils.WriteErr(w, "BAD BODY FORMAT", http.StatusBadRequest)
+		utils.WriteBadBody(w)
 		return
 	}
 
 	if form.TransactionID == "" {
-		utils.WriteErr(w, "TRANSACTIONS ID IS REQUIRED !!!", http.StatusBadRequest)
+		utils.WriteValidationErr(w, utils.Invalid("transaction_id", "transaction_id IS REQUIRED"))
 		return
 	}
 
-	query := *zinc.NewMetaZincQuery() // V1ZincQuery | Query
-	metaQuery := *zinc.NewMetaTermQuery()
-	metaQuery.SetValue(form.TransactionID)
-	subQuery := *zinc.NewMetaQuery()
-	subQuery.SetTerm(map[string]zinc.MetaTermQuery{
-		"_id": metaQuery,
-	})
-
-	query.SetQuery(subQuery)
-
-	_, res, err := zincClient.Client.Search.Search(zincClient.Ctx, "transactions").Query(query).Execute()
+	hit, err := store.Get(r.Context(), "transactions", form.TransactionID)
 	if err != nil {
-		utils.WriteErr(w, "Error searching the Document", http.StatusBadRequest)
+		utils.WriteStorageErr(w, err, "BAD RESPONSE FROM STORAGE WHILE SEARCHING DOCUMENTS")
 		return
 	}
 
-	defer func() {
-		err := res.Body.Close()
-		if err != nil {
-			panic(err)
-		}
-	}()
-
-	resDecoded := RespFind{}
-
-	if err := json.NewDecoder(res.Body).Decode(&resDecoded); err != nil {
+	hits, err := toHits([]storage.Hit{hit})
+	if err != nil {
 		utils.WriteErr(w, "ERROR PARSING RESPONSE", http.StatusInternalServerError)
 		return
 	}
 
-	utils.WriteJson(w, resDecoded.Hits.Hits)
+	utils.WriteJson(w, hits)
 
 }
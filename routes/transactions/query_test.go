@@ -0,0 +1,90 @@
+package transactions
+
+import (
+	"net/http"
+	"reflect"
+	"sofa-logs-servers/infra/storage"
+	"sofa-logs-servers/models"
+	"sofa-logs-servers/routes/routetest"
+	"testing"
+	"time"
+)
+
+func TestFindById(t *testing.T) {
+	store := routetest.Store(t, "transactions", models.TransactionMapping)
+	id := routetest.Index(t, store, "transactions", models.Transaction{Amount: 3})
+
+	var hits []Hit
+	routetest.Decode(t, routetest.Post(FindById, store, `{"transaction_id":"`+id+`"}`), http.StatusOK, &hits)
+	if len(hits) != 1 || hits[0].Id != id || hits[0].Source.Amount != 3 {
+		t.Errorf("hits %+v", hits)
+	}
+}
+
+// findAmounts lists the amounts FindAll answers body with, in order.
+func findAmounts(t *testing.T, store storage.Storage, body string) []uint {
+	t.Helper()
+	page := struct {
+		Data []FindAllReturn `json:"data"`
+	}{}
+	routetest.Decode(t, routetest.Post(FindAll, store, body), http.StatusOK, &page)
+
+	amounts := make([]uint, len(page.Data))
+	for i, transaction := range page.Data {
+		amounts[i] = transaction.Amount
+	}
+	return amounts
+}
+
+func TestFindAllByDate(t *testing.T) {
+	store := routetest.Store(t, "transactions", models.TransactionMapping)
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		routetest.Index(t, store, "transactions", models.Transaction{Amount: uint(i + 1), Date: day.AddDate(0, 0, i), CreatedAt: day})
+	}
+
+	tests := []struct {
+		name    string
+		body    string
+		amounts []uint
+	}{
+		{"no range", `{}`, []uint{1, 2, 3}},
+		{"inclusive", `{"start_date":"2024-01-02T00:00:00Z","end_date":"2024-01-03T00:00:00Z","include_start":true,"include_end":true}`, []uint{2, 3}},
+		{"exclusive", `{"start_date":"2024-01-01T00:00:00Z","end_date":"2024-01-03T00:00:00Z"}`, []uint{2}},
+		{"open end", `{"start_date":"2024-01-02T00:00:00Z","include_start":true}`, []uint{2, 3}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := findAmounts(t, store, test.body); !reflect.DeepEqual(got, test.amounts) {
+				t.Errorf("amounts = %v, want %v", got, test.amounts)
+			}
+		})
+	}
+}
+
+func TestFindAllSorts(t *testing.T) {
+	store := routetest.Store(t, "transactions", models.TransactionMapping)
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, amount := range []uint{2, 3, 1} {
+		routetest.Index(t, store, "transactions", models.Transaction{Amount: amount, Date: day.AddDate(0, 0, i), CreatedAt: day})
+	}
+
+	tests := []struct {
+		name    string
+		body    string
+		amounts []uint
+	}{
+		{"empty body", ``, []uint{2, 3, 1}},
+		{"by amount", `{"sort":"amount","order":"desc"}`, []uint{3, 2, 1}},
+		{"limited", `{"sort":"amount","limit":2}`, []uint{1, 2}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := findAmounts(t, store, test.body); !reflect.DeepEqual(got, test.amounts) {
+				t.Errorf("amounts = %v, want %v", got, test.amounts)
+			}
+		})
+	}
+}
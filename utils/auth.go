@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"errors"
+	"net/http"
+	"sofa-logs-servers/infra/auth"
+)
+
+// Authorize only lets requests through whose API key or token holds a role that policy allows
+// to perform op on index.
+func Authorize(authenticator auth.Authenticator, policy auth.Policy, index string, op auth.Operation) Layer {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			principal, err := authenticator.Authenticate(r)
+			switch {
+			case errors.Is(err, auth.ErrNoCredentials):
+				WriteErr(w, "MISSING CREDENTIALS", http.StatusUnauthorized)
+				return
+			case errors.Is(err, auth.ErrInvalidKey):
+				WriteErr(w, "INVALID API KEY", http.StatusUnauthorized)
+				return
+			case errors.Is(err, auth.ErrInvalidToken):
+				WriteErr(w, "INVALID TOKEN", http.StatusUnauthorized)
+				return
+			case err != nil:
+				WriteErr(w, "ERROR CHECKING CREDENTIALS", http.StatusServiceUnavailable)
+				return
+			}
+
+			if !policy.Allows(principal.Scopes, index, op) {
+				WriteErr(w, "NOT ALLOWED TO "+string(op)+" "+index, http.StatusForbidden)
+				return
+			}
+
+			next(w, r.WithContext(auth.WithPrincipal(r.Context(), principal)))
+		}
+	}
+}
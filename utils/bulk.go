@@ -0,0 +1,143 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+const (
+	// MaxBulkItems caps the number of documents accepted by one bulk request.
+	MaxBulkItems = 1000
+	// MaxBulkBytes caps the size of a bulk body, which is refused unread past it.
+	MaxBulkBytes = 10 << 20
+)
+
+var errTooManyItems = fmt.Errorf("AT MOST %d ITEMS PER BULK REQUEST", MaxBulkItems)
+
+// BulkItem reports what happened to one document of a bulk request, Position is its index in
+// the request body.
+type BulkItem struct {
+	Position int       `json:"position"`
+	ID       string    `json:"_id,omitempty"`
+	Result   string    `json:"result,omitempty"`
+	Code     ErrorCode `json:"code,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+type BulkResponse struct {
+	Errors bool       `json:"errors"`
+	Items  []BulkItem `json:"items"`
+}
+
+// DecodeBulk splits the body of r into its raw documents, reading at most MaxBulkBytes and
+// MaxBulkItems. The body is either a JSON array or newline delimited JSON objects. A line that is
+// not JSON is kept as is, so it fails on its own when it is decoded.
+func DecodeBulk(w http.ResponseWriter, r *http.Request) ([]jsoniter.RawMessage, error) {
+	reader := bufio.NewReader(http.MaxBytesReader(w, r.Body, MaxBulkBytes))
+
+	first, err := firstByte(reader)
+	if err == io.EOF {
+		return nil, errors.New("EMPTY BULK BODY")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var items []jsoniter.RawMessage
+	if first == '[' {
+		items, err = decodeArray(reader)
+	} else {
+		items, err = decodeLines(reader)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(items) == 0 {
+		return nil, errors.New("EMPTY BULK BODY")
+	}
+	return items, nil
+}
+
+func decodeArray(reader io.Reader) ([]jsoniter.RawMessage, error) {
+	decoder := json.NewDecoder(reader)
+	if _, err := decoder.Token(); err != nil {
+		return nil, bulkReadErr(err)
+	}
+
+	var items []jsoniter.RawMessage
+	for decoder.More() {
+		if len(items) == MaxBulkItems {
+			return nil, errTooManyItems
+		}
+		item := json.RawMessage{}
+		if err := decoder.Decode(&item); err != nil {
+			return nil, bulkReadErr(err)
+		}
+		items = append(items, jsoniter.RawMessage(item))
+	}
+	if _, err := decoder.Token(); err != nil {
+		return nil, bulkReadErr(err)
+	}
+	return items, nil
+}
+
+func decodeLines(reader *bufio.Reader) ([]jsoniter.RawMessage, error) {
+	var items []jsoniter.RawMessage
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, bulkReadErr(err)
+		}
+
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			if len(items) == MaxBulkItems {
+				return nil, errTooManyItems
+			}
+			items = append(items, jsoniter.RawMessage(line))
+		}
+
+		if err == io.EOF {
+			return items, nil
+		}
+	}
+}
+
+// bulkReadErr keeps a body over MaxBulkBytes apart from one that is not JSON.
+func bulkReadErr(err error) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return err
+	}
+	return errors.New("BAD BODY FORMAT")
+}
+
+// WriteBulkErr answers a bulk body DecodeBulk refused, with 413 when it is too large.
+func WriteBulkErr(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		WriteErr(w, fmt.Sprintf("BULK BODY LARGER THAN %d BYTES", MaxBulkBytes), http.StatusRequestEntityTooLarge)
+		return
+	}
+	WriteErrCode(w, CodeBadBody, err.Error(), http.StatusBadRequest)
+}
+
+// firstByte returns the first non whitespace byte of reader without consuming it.
+func firstByte(reader *bufio.Reader) (byte, error) {
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if b != ' ' && b != '\t' && b != '\r' && b != '\n' {
+			return b, reader.UnreadByte()
+		}
+	}
+}
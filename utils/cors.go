@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"net/http"
+	"path"
+	"sofa-logs-servers/config"
+	"sort"
+	"strings"
+
+	"github.com/rs/cors"
+)
+
+// CORS answers preflight requests and sets the CORS headers of every response with the policy of
+// the route pattern matching the request path, the longest pattern winning, or the default policy.
+func CORS(policy config.CORS, next http.Handler) http.Handler {
+	patterns := make([]string, 0, len(policy.Routes))
+	for pattern := range policy.Routes {
+		patterns = append(patterns, pattern)
+	}
+	sort.Slice(patterns, func(i, j int) bool {
+		if len(patterns[i]) != len(patterns[j]) {
+			return len(patterns[i]) > len(patterns[j])
+		}
+		return patterns[i] < patterns[j]
+	})
+
+	routes := make([]http.Handler, len(patterns))
+	for i, pattern := range patterns {
+		routes[i] = newCORS(policy.Route(pattern)).Handler(next)
+	}
+	fallback := newCORS(policy).Handler(next)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i, pattern := range patterns {
+			if ok, _ := path.Match(pattern, r.URL.Path); ok {
+				routes[i].ServeHTTP(w, r)
+				return
+			}
+		}
+		fallback.ServeHTTP(w, r)
+	})
+}
+
+func newCORS(policy config.CORS) *cors.Cors {
+	options := cors.Options{
+		AllowedOrigins:   policy.AllowedOrigins,
+		AllowedMethods:   policy.AllowedMethods,
+		AllowedHeaders:   policy.AllowedHeaders,
+		ExposedHeaders:   policy.ExposedHeaders,
+		AllowCredentials: policy.AllowCredentials,
+		MaxAge:           int(policy.MaxAge.Seconds()),
+	}
+
+	// A lone "*" keeps answering with a wildcard. Patterns are matched here, as the cors package
+	// only knows a single wildcard per origin.
+	if len(policy.AllowedOrigins) != 1 || policy.AllowedOrigins[0] != "*" {
+		origins := policy.AllowedOrigins
+		options.AllowOriginFunc = func(origin string) bool {
+			origin = strings.ToLower(origin)
+			for _, pattern := range origins {
+				if ok, _ := path.Match(strings.ToLower(pattern), origin); ok || pattern == "*" {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	return cors.New(options)
+}
@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sofa-logs-servers/infra/storage"
+	"strings"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// ErrorCode identifies the kind of an error for clients, which should not parse messages.
+type ErrorCode string
+
+const (
+	CodeBadRequest      ErrorCode = "bad_request"
+	CodeBadBody         ErrorCode = "bad_body"
+	CodeValidation      ErrorCode = "validation_failed"
+	CodeUnauthenticated ErrorCode = "unauthenticated"
+	CodeForbidden       ErrorCode = "forbidden"
+	CodeNotFound        ErrorCode = "not_found"
+	CodeConflict        ErrorCode = "conflict"
+	CodeTooLarge        ErrorCode = "too_large"
+	CodeRateLimited     ErrorCode = "rate_limited"
+	CodeQuotaExceeded   ErrorCode = "quota_exceeded"
+	CodeInternal        ErrorCode = "internal_error"
+	CodeUnsupported     ErrorCode = "unsupported"
+	CodeStorage         ErrorCode = "storage_error"
+	CodeUnavailable     ErrorCode = "unavailable"
+	CodeTimeout         ErrorCode = "timeout"
+)
+
+// codes is the code of an error answered with a status and nothing more specific.
+var codes = map[int]ErrorCode{
+	http.StatusBadRequest:            CodeBadRequest,
+	http.StatusUnauthorized:          CodeUnauthenticated,
+	http.StatusForbidden:             CodeForbidden,
+	http.StatusNotFound:              CodeNotFound,
+	http.StatusConflict:              CodeConflict,
+	http.StatusRequestEntityTooLarge: CodeTooLarge,
+	http.StatusTooManyRequests:       CodeRateLimited,
+	http.StatusInternalServerError:   CodeInternal,
+	http.StatusNotImplemented:        CodeUnsupported,
+	http.StatusBadGateway:            CodeStorage,
+	http.StatusServiceUnavailable:    CodeUnavailable,
+	http.StatusGatewayTimeout:        CodeTimeout,
+}
+
+// FieldError is a rule one field of a request broke.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists the fields of a request that are not valid.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+// Invalid returns a ValidationError for a single field.
+func Invalid(field, message string) *ValidationError {
+	return &ValidationError{Fields: []FieldError{{Field: field, Message: message}}}
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Fields))
+	for i, field := range e.Fields {
+		messages[i] = field.Message
+	}
+	return strings.Join(messages, ", ")
+}
+
+func writeError(w http.ResponseWriter, form ErrorForm) {
+	form.RequestID = w.Header().Get(RequestIDHeader)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(form.StatusCode)
+	PanicErr(jsoniter.NewEncoder(w).Encode(form))
+}
+
+// WriteErrCode answers with an error more specific than its status, like a bad body.
+func WriteErrCode(w http.ResponseWriter, code ErrorCode, err string, statusCode int) {
+	writeError(w, ErrorForm{StatusCode: statusCode, Code: code, Message: err})
+}
+
+// WriteBadBody answers 400 to a body that is not the JSON the route expects.
+func WriteBadBody(w http.ResponseWriter) {
+	WriteErrCode(w, CodeBadBody, "BAD BODY FORMAT", http.StatusBadRequest)
+}
+
+// WriteValidationErr answers 400 with the fields err lists when it is a *ValidationError.
+func WriteValidationErr(w http.ResponseWriter, err error) {
+	form := ErrorForm{StatusCode: http.StatusBadRequest, Code: CodeValidation, Message: err.Error()}
+
+	var invalid *ValidationError
+	if errors.As(err, &invalid) {
+		form.Details = invalid.Fields
+	}
+	writeError(w, form)
+}
+
+// WriteStorageErr answers a failed storage call with the status its cause calls for. message
+// describes the call and is used when the backend failed in some other way.
+func WriteStorageErr(w http.ResponseWriter, err error, message string) {
+	switch {
+	case WriteQuotaErr(w, err):
+	case errors.Is(err, storage.ErrNotFound):
+		WriteErr(w, "DOCUMENT NOT FOUND", http.StatusNotFound)
+	case errors.Is(err, storage.ErrConflict):
+		WriteErr(w, "DOCUMENT WAS CHANGED CONCURRENTLY", http.StatusConflict)
+	case errors.Is(err, storage.ErrUnsupported):
+		WriteErr(w, "NOT SUPPORTED BY THE STORAGE BACKEND", http.StatusNotImplemented)
+	case errors.Is(err, storage.ErrUnavailable):
+		WriteErr(w, "STORAGE UNAVAILABLE", http.StatusServiceUnavailable)
+	case errors.Is(err, context.DeadlineExceeded):
+		WriteErr(w, "STORAGE TIMED OUT", http.StatusGatewayTimeout)
+	default:
+		WriteErr(w, message, http.StatusBadGateway)
+	}
+}
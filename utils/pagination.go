@@ -0,0 +1,121 @@
+package utils
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sofa-logs-servers/infra/storage"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+const (
+	DefaultLimit = 100
+	MaxLimit     = storage.ScanSize
+)
+
+// PageForm holds the pagination parameters shared by the list endpoints.
+type PageForm struct {
+	Limit  int    `json:"limit"`
+	Cursor string `json:"cursor"`
+	Sort   string `json:"sort"`
+	Order  string `json:"order"`
+}
+
+// Page is the envelope the list endpoints answer with.
+type Page struct {
+	Data       interface{} `json:"data"`
+	Total      int         `json:"total"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+}
+
+// cursor is what an opaque next_cursor string decodes to. The sort is kept along with the
+// position so a cursor cannot be replayed against a differently ordered listing.
+type cursor struct {
+	Sort  []storage.SortField `json:"sort"`
+	After []interface{}       `json:"after"`
+}
+
+// PageQuery builds the query for one page of a listing. sortable lists the fields a client may
+// order by and defaultSort applies when the form names none. Every invalid parameter is reported.
+func PageQuery(form PageForm, sortable []string, defaultSort storage.SortField) (storage.Query, error) {
+	query := storage.Query{Size: form.Limit}
+	if query.Size == 0 {
+		query.Size = DefaultLimit
+	}
+
+	sort := defaultSort
+	if form.Sort != "" {
+		sort = storage.SortField{Field: form.Sort}
+	}
+	switch form.Order {
+	case "asc":
+		sort.Desc = false
+	case "desc":
+		sort.Desc = true
+	}
+	query.Sort = []storage.SortField{sort}
+
+	err := Validate(
+		Check(query.Size > 0 && query.Size <= MaxLimit, "limit", fmt.Sprintf("limit MUST BE BETWEEN 1 AND %d", MaxLimit)),
+		Check(form.Sort == "" || contains(sortable, form.Sort), "sort", "CANNOT SORT BY "+form.Sort),
+		Check(form.Order == "" || form.Order == "asc" || form.Order == "desc", "order", "order MUST BE asc OR desc"),
+		func() *FieldError {
+			if form.Cursor == "" {
+				return nil
+			}
+			after, ok := decodeCursor(form.Cursor, query.Sort)
+			if !ok {
+				return &FieldError{Field: "cursor", Message: "INVALID CURSOR"}
+			}
+			query.After = after
+			return nil
+		},
+	)
+	if err != nil {
+		return storage.Query{}, err
+	}
+	return query, nil
+}
+
+// NextCursor returns the cursor of the page following hits, or an empty string on the last page.
+func NextCursor(query storage.Query, hits []storage.Hit) string {
+	if len(hits) == 0 || len(hits) < query.Size {
+		return ""
+	}
+
+	raw, err := jsoniter.Marshal(cursor{Sort: query.Sort, After: hits[len(hits)-1].Sort})
+	PanicErr(err)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// decodeCursor returns the position encoded in a cursor issued for a listing ordered by sort.
+func decodeCursor(encoded string, sort []storage.SortField) ([]interface{}, bool) {
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, false
+	}
+
+	decoded := cursor{}
+	if err := jsoniter.Unmarshal(raw, &decoded); err != nil || len(decoded.After) == 0 {
+		return nil, false
+	}
+
+	if len(decoded.Sort) != len(sort) {
+		return nil, false
+	}
+	for i := range sort {
+		if decoded.Sort[i] != sort[i] {
+			return nil, false
+		}
+	}
+	return decoded.After, true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
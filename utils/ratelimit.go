@@ -0,0 +1,84 @@
+package utils
+
+import (
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"sofa-logs-servers/infra/auth"
+	"sofa-logs-servers/infra/ratelimit"
+	"sofa-logs-servers/infra/tenant"
+	"strconv"
+	"time"
+)
+
+// LimitIP answers 429 once the bucket of the client IP is empty. It runs before Authorize, so
+// guessing keys is limited too.
+func LimitIP(limits *ratelimit.Limits) Layer {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			ok, retryAfter := limits.IP().Allow(limitKey(r, ratelimit.ByIP))
+			if !ok {
+				writeRetryAfter(w, retryAfter)
+				WriteErr(w, "RATE LIMIT EXCEEDED", http.StatusTooManyRequests)
+				return
+			}
+			next(w, r)
+		}
+	}
+}
+
+// RateLimit answers 429 once the bucket of a request is empty. It runs after Authorize and
+// ResolveTenant, which identify the key and the tenant buckets are kept for.
+func RateLimit(limits *ratelimit.Limits) Layer {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			limiter, by := limits.For(r.URL.Path)
+			if limiter == nil {
+				next(w, r)
+				return
+			}
+
+			ok, retryAfter := limiter.Allow(limitKey(r, by))
+			if !ok {
+				writeRetryAfter(w, retryAfter)
+				WriteErr(w, "RATE LIMIT EXCEEDED", http.StatusTooManyRequests)
+				return
+			}
+			next(w, r)
+		}
+	}
+}
+
+func limitKey(r *http.Request, by ratelimit.KeyBy) string {
+	switch by {
+	case ratelimit.ByTenant:
+		return "tenant:" + tenant.FromContext(r.Context())
+	case ratelimit.ByKey:
+		if principal, ok := auth.FromContext(r.Context()); ok {
+			return principal.ID
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+func writeRetryAfter(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+}
+
+// WriteQuotaErr answers 429 when err is a *ratelimit.QuotaError and reports whether it did.
+func WriteQuotaErr(w http.ResponseWriter, err error) bool {
+	var quotaErr *ratelimit.QuotaError
+	if !errors.As(err, &quotaErr) {
+		return false
+	}
+
+	writeRetryAfter(w, quotaErr.RetryAfter)
+	WriteErrCode(w, CodeQuotaExceeded, "DAILY DOCUMENT QUOTA EXCEEDED", http.StatusTooManyRequests)
+	return true
+}
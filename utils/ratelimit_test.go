@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sofa-logs-servers/infra/auth"
+	"sofa-logs-servers/infra/ratelimit"
+	"testing"
+)
+
+// limited runs layer on a request from addr made with principal, when it has an ID.
+func limited(layer Layer, addr string, principal auth.Principal) *httptest.ResponseRecorder {
+	handler := layer(func(w http.ResponseWriter, r *http.Request) {})
+	r := httptest.NewRequest(http.MethodPost, "/api/logs/all", nil)
+	r.RemoteAddr = addr
+	if principal.ID != "" {
+		r = r.WithContext(auth.WithPrincipal(r.Context(), principal))
+	}
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+func TestLimitIP(t *testing.T) {
+	limit := LimitIP(ratelimit.NewLimits(ratelimit.Config{IP: &ratelimit.Limit{Rate: 1, Burst: 1}}))
+	key := auth.Principal{ID: "key:a"}
+
+	if w := limited(limit, "10.0.0.1:1000", key); w.Code != http.StatusOK {
+		t.Fatalf("first request: status = %d", w.Code)
+	}
+	// Another port, or other credentials, of the same client share its bucket.
+	w := limited(limit, "10.0.0.1:2000", auth.Principal{ID: "key:b"})
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "1" {
+		t.Errorf("same IP: status = %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	if w := limited(limit, "10.0.0.2:1000", key); w.Code != http.StatusOK {
+		t.Errorf("another IP: status = %d", w.Code)
+	}
+}
+
+func TestRateLimitByKey(t *testing.T) {
+	limit := RateLimit(ratelimit.NewLimits(ratelimit.Config{Default: &ratelimit.Limit{Rate: 1, Burst: 1}}))
+	a, b := auth.Principal{ID: "key:a"}, auth.Principal{ID: "key:b"}
+
+	tests := []struct {
+		name      string
+		addr      string
+		principal auth.Principal
+		status    int
+	}{
+		{"first key", "10.0.0.1:1000", a, http.StatusOK},
+		{"first key again", "10.0.0.2:1000", a, http.StatusTooManyRequests},
+		{"second key from the same IP", "10.0.0.1:1000", b, http.StatusOK},
+		{"no credentials", "10.0.0.1:1000", auth.Principal{}, http.StatusOK},
+		{"no credentials again", "10.0.0.1:2000", auth.Principal{}, http.StatusTooManyRequests},
+	}
+
+	for _, test := range tests {
+		if w := limited(limit, test.addr, test.principal); w.Code != test.status {
+			t.Errorf("%s: status = %d, want %d", test.name, w.Code, test.status)
+		}
+	}
+}
+
+func TestRateLimitUnlimited(t *testing.T) {
+	limit := RateLimit(ratelimit.NewLimits(ratelimit.Config{}))
+	for i := 0; i < 100; i++ {
+		if w := limited(limit, "10.0.0.1:1000", auth.Principal{}); w.Code != http.StatusOK {
+			t.Fatalf("request %d: status = %d", i, w.Code)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"sofa-logs-servers/infra/logging"
+	"sofa-logs-servers/infra/metrics"
+
+	"github.com/felixge/httpsnoop"
+	"github.com/google/uuid"
+	jsoniter "github.com/json-iterator/go"
+)
+
+// Recover turns a panic of a handler into a 500 carrying the request id, which is logged with the
+// stack, so one failing request neither resets the connection nor goes unnoticed. It wraps every
+// other handler, so panics of the middleware are caught too, and finds the id RequestID gave the
+// request in the response headers.
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(metrics.WithMatchedRoute(r.Context()))
+		written := false
+		tracked := httpsnoop.Wrap(w, httpsnoop.Hooks{
+			WriteHeader: func(next httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+				return func(code int) {
+					written = true
+					next(code)
+				}
+			},
+			Write: func(next httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+				return func(b []byte) (int, error) {
+					written = true
+					return next(b)
+				}
+			},
+		})
+
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+
+			id := w.Header().Get(RequestIDHeader)
+			if id == "" {
+				id = uuid.NewString()
+			}
+			metrics.Panic(r)
+			logging.FromContext(r.Context()).Error("handler panicked", "request_id", id,
+				"method", r.Method, "path", r.URL.Path, "panic", fmt.Sprint(recovered), "stack", string(debug.Stack()))
+
+			// Part of the response is already out, so the client can only learn of the failure
+			// from the connection closing.
+			if written {
+				panic(http.ErrAbortHandler)
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set(RequestIDHeader, id)
+			w.WriteHeader(http.StatusInternalServerError)
+			_ = jsoniter.NewEncoder(w).Encode(ErrorForm{
+				StatusCode: http.StatusInternalServerError,
+				Code:       CodeInternal,
+				Message:    "INTERNAL SERVER ERROR",
+				RequestID:  id,
+			})
+		}()
+
+		next.ServeHTTP(tracked, r)
+	})
+}
@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"sofa-logs-servers/infra/logging"
+
+	"github.com/felixge/httpsnoop"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RequestIDHeader carries the id a request is logged under.
+const RequestIDHeader = "X-Request-ID"
+
+// validRequestID keeps ids sent by clients short and safe to log and echo back.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+type requestIDKey struct{}
+
+// RequestIDFromContext returns the id of the request ctx belongs to, empty outside of RequestID.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// RequestID keeps the X-Request-ID of a request, or gives it a new one, and echoes it in the
+// response. The request carries a logger tagged with the id and the trace id, which logs the
+// request once answered.
+func RequestID(logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(RequestIDHeader)
+			if !validRequestID.MatchString(id) {
+				id = uuid.NewString()
+			}
+			w.Header().Set(RequestIDHeader, id)
+
+			requestLogger := logger.With("request_id", id)
+			if span := trace.SpanContextFromContext(r.Context()); span.IsValid() {
+				requestLogger = requestLogger.With("trace_id", span.TraceID().String())
+			}
+			ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+			ctx = logging.WithLogger(ctx, requestLogger)
+
+			m := httpsnoop.CaptureMetrics(next, w, r.WithContext(ctx))
+			requestLogger.Info("request",
+				"method", r.Method,
+				"path", r.URL.Path,
+				"status", m.Code,
+				"bytes", m.Written,
+				"duration_ms", m.Duration.Milliseconds(),
+				"remote_addr", r.RemoteAddr,
+			)
+		})
+	}
+}
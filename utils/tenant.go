@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"net/http"
+	"sofa-logs-servers/infra/auth"
+	"sofa-logs-servers/infra/tenant"
+)
+
+// ResolveTenant scopes storage calls to the tenant of the request. Credentials bound to a tenant
+// always use it, and the X-Tenant-ID header may only repeat it. Admin credentials pick a tenant
+// with the header, or the default tenant without it unless required is set. Other credentials
+// only reach the default tenant, and nothing when required is set. It runs after Authorize.
+func ResolveTenant(required bool) Layer {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			principal, _ := auth.FromContext(r.Context())
+			id := r.Header.Get(tenant.Header)
+
+			if principal.Tenant != "" {
+				if id != "" && id != principal.Tenant {
+					WriteErr(w, "CREDENTIALS ARE BOUND TO ANOTHER TENANT", http.StatusForbidden)
+					return
+				}
+				id = principal.Tenant
+			} else if !principal.HasScope(auth.ScopeAdmin) {
+				if id != "" {
+					WriteErr(w, "ONLY ADMIN CREDENTIALS MAY PICK A TENANT", http.StatusForbidden)
+					return
+				}
+				if required {
+					WriteErr(w, "CREDENTIALS ARE NOT BOUND TO A TENANT", http.StatusForbidden)
+					return
+				}
+			}
+
+			if id == "" && required {
+				WriteErr(w, "MISSING "+tenant.Header, http.StatusBadRequest)
+				return
+			}
+
+			if id != "" && tenant.Validate(id) != nil {
+				WriteErr(w, "INVALID TENANT", http.StatusBadRequest)
+				return
+			}
+
+			next(w, r.WithContext(tenant.WithTenant(r.Context(), id)))
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sofa-logs-servers/infra/auth"
+	"sofa-logs-servers/infra/tenant"
+	"testing"
+)
+
+func TestResolveTenant(t *testing.T) {
+	admin := auth.Principal{ID: "key:admin", Scopes: []auth.Scope{auth.ScopeAdmin}}
+	reader := auth.Principal{ID: "key:reader", Scopes: []auth.Scope{auth.ScopeRead}}
+	bound := auth.Principal{ID: "key:acme", Scopes: []auth.Scope{auth.ScopeRead}, Tenant: "acme"}
+	boundAdmin := auth.Principal{ID: "key:acme-admin", Scopes: []auth.Scope{auth.ScopeAdmin}, Tenant: "acme"}
+
+	tests := []struct {
+		name      string
+		principal auth.Principal
+		header    string
+		required  bool
+		status    int
+		tenant    string
+	}{
+		{"admin picks a tenant", admin, "globex", false, http.StatusOK, "globex"},
+		{"admin defaults", admin, "", false, http.StatusOK, ""},
+		{"admin must pick when required", admin, "", true, http.StatusBadRequest, ""},
+		{"admin picks an invalid tenant", admin, "Not Valid", false, http.StatusBadRequest, ""},
+		{"unbound credentials cannot pick", reader, "globex", false, http.StatusForbidden, ""},
+		{"unbound credentials default", reader, "", false, http.StatusOK, ""},
+		{"unbound credentials when required", reader, "", true, http.StatusForbidden, ""},
+		{"bound credentials", bound, "", true, http.StatusOK, "acme"},
+		{"bound credentials repeat their tenant", bound, "acme", false, http.StatusOK, "acme"},
+		{"bound credentials cannot switch", bound, "globex", false, http.StatusForbidden, ""},
+		{"bound admin cannot switch", boundAdmin, "globex", false, http.StatusForbidden, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reached, resolved := false, ""
+			handler := ResolveTenant(test.required)(func(w http.ResponseWriter, r *http.Request) {
+				reached, resolved = true, tenant.FromContext(r.Context())
+			})
+
+			r := httptest.NewRequest(http.MethodPost, "/", nil)
+			if test.header != "" {
+				r.Header.Set(tenant.Header, test.header)
+			}
+			w := httptest.NewRecorder()
+			handler(w, r.WithContext(auth.WithPrincipal(r.Context(), test.principal)))
+
+			if w.Code != test.status {
+				t.Fatalf("status = %d, want %d, body %s", w.Code, test.status, w.Body)
+			}
+			if reached != (test.status == http.StatusOK) {
+				t.Fatalf("handler reached = %v", reached)
+			}
+			if resolved != test.tenant {
+				t.Errorf("tenant = %q, want %q", resolved, test.tenant)
+			}
+		})
+	}
+}
@@ -3,21 +3,38 @@ package utils
 import (
 	jsoniter "github.com/json-iterator/go"
 	"net/http"
-	"sofa-logs-servers/infra/zincsearch"
+	"sofa-logs-servers/infra/storage"
 )
 
+// ErrorForm is the body of every error response. Code is stable, Message is meant for people and
+// Details lists the invalid fields of a request.
 type ErrorForm struct {
-	StatusCode int    `json:"status_code"`
-	Message    string `json:"message"`
+	StatusCode int          `json:"status_code"`
+	Code       ErrorCode    `json:"code"`
+	Message    string       `json:"message"`
+	Details    []FieldError `json:"details,omitempty"`
+	RequestID  string       `json:"request_id,omitempty"`
 }
 
+// Layer wraps a handler with behaviour shared between routes, like authentication.
+type Layer func(next http.HandlerFunc) http.HandlerFunc
+
+// Middleware hands store to next. Layers run in the order given, before next.
 func Middleware(
-	next func(w http.ResponseWriter, r *http.Request, zincClient zincsearch.ZincClient), zincClient zincsearch.ZincClient,
+	next func(w http.ResponseWriter, r *http.Request, store storage.Storage), store storage.Storage, layers ...Layer,
 ) http.HandlerFunc {
 
-	return func(w http.ResponseWriter, r *http.Request) {
-		next(w, r, zincClient)
+	return Chain(func(w http.ResponseWriter, r *http.Request) {
+		next(w, r, store)
+	}, layers...)
+}
+
+// Chain wraps handler in layers, the first layer being the outermost.
+func Chain(handler http.HandlerFunc, layers ...Layer) http.HandlerFunc {
+	for i := len(layers) - 1; i >= 0; i-- {
+		handler = layers[i](handler)
 	}
+	return handler
 }
 
 func PanicErr(err error) {
@@ -26,13 +43,18 @@ func PanicErr(err error) {
 	}
 }
 
+// WriteErr answers with the code that goes with statusCode.
 func WriteErr(w http.ResponseWriter, err string, statusCode int) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(statusCode)
-	PanicErr(jsoniter.NewEncoder(w).Encode(ErrorForm{Message: err, StatusCode: statusCode}))
+	writeError(w, ErrorForm{StatusCode: statusCode, Code: codes[statusCode], Message: err})
 }
 
 func WriteJson(w http.ResponseWriter, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	PanicErr(jsoniter.NewEncoder(w).Encode(data))
 }
+
+func WriteJsonStatus(w http.ResponseWriter, data interface{}, statusCode int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	PanicErr(jsoniter.NewEncoder(w).Encode(data))
+}
@@ -0,0 +1,69 @@
+package utils
+
+import "time"
+
+// Dates outside of EarliestDate and MaxClockSkew past now are refused: they come from broken
+// clocks or from clients sending seconds as milliseconds, and would skew every histogram.
+var (
+	EarliestDate = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	MaxClockSkew = 24 * time.Hour
+)
+
+// Rule checks one field of a form and returns the violation, or nil when the field is valid.
+type Rule func() *FieldError
+
+// Validate checks every rule and returns a *ValidationError listing all the violations, so a
+// client can fix a form in one go. It returns nil when all rules hold.
+func Validate(rules ...Rule) error {
+	var invalid ValidationError
+	for _, rule := range rules {
+		if violation := rule(); violation != nil {
+			invalid.Fields = append(invalid.Fields, *violation)
+		}
+	}
+	if len(invalid.Fields) == 0 {
+		return nil
+	}
+	return &invalid
+}
+
+// Check holds when ok is true, reporting message for field otherwise.
+func Check(ok bool, field, message string) Rule {
+	return func() *FieldError {
+		if ok {
+			return nil
+		}
+		return &FieldError{Field: field, Message: message}
+	}
+}
+
+// Required holds for a non empty string.
+func Required(field, value string) Rule {
+	return Check(value != "", field, field+" IS REQUIRED")
+}
+
+// RequiredNumber holds for a non zero number.
+func RequiredNumber(field string, value uint) Rule {
+	return Check(value != 0, field, field+" IS REQUIRED")
+}
+
+// Date holds for a time set between EarliestDate and MaxClockSkew past now.
+func Date(field string, value time.Time) Rule {
+	return func() *FieldError {
+		switch {
+		case value.IsZero():
+			return &FieldError{Field: field, Message: field + " IS REQUIRED"}
+		case value.Before(EarliestDate):
+			return &FieldError{Field: field, Message: field + " MUST NOT BE BEFORE " + EarliestDate.Format(time.DateOnly)}
+		case value.After(time.Now().Add(MaxClockSkew)):
+			return &FieldError{Field: field, Message: field + " MUST NOT BE IN THE FUTURE"}
+		}
+		return nil
+	}
+}
+
+// NotBefore holds when end is not before start. A missing time is left to Date to report.
+func NotBefore(field string, end time.Time, startField string, start time.Time) Rule {
+	return Check(end.IsZero() || start.IsZero() || !end.Before(start),
+		field, field+" MUST NOT BE BEFORE "+startField)
+}